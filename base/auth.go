@@ -4,6 +4,8 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"io"
 )
 
 func Authenticate(seed []byte, name, key string) []byte {
@@ -19,3 +21,46 @@ func Authenticate(seed []byte, name, key string) []byte {
 	res = append(res, h.Sum(nil)...)
 	return res[:32]
 }
+
+//Sign 用共享密钥对数据签名（HMAC-SHA256），用于控制端下发的配置等
+func Sign(key string, data []byte) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+//SubKey 由共享密钥派生专用于某一用途（如"conf"）的签名密钥，
+//使签名与握手不共用同一密钥
+func SubKey(key, use string) string {
+	return string(Sign(key, []byte("dk-subkey:"+use)))
+}
+
+//Verify 校验Sign生成的签名
+func Verify(key string, data, sig []byte) bool {
+	return hmac.Equal(Sign(key, data), sig)
+}
+
+const tokenMagic = "DKT" //令牌前导的标识
+
+//TokenPreface 生成令牌前导（标识+1字节长度+令牌）：客户端连接控制端的令牌端口后首先发送，
+//控制端据此将连接转发到令牌授权的目标
+func TokenPreface(token string) []byte {
+	buf := append([]byte(tokenMagic), byte(len(token)))
+	return append(buf, token...)
+}
+
+//ReadTokenPreface 读取TokenPreface生成的令牌前导，返回令牌
+func ReadTokenPreface(r io.Reader) (string, error) {
+	buf := make([]byte, len(tokenMagic)+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	if string(buf[:len(tokenMagic)]) != tokenMagic || buf[len(tokenMagic)] == 0 {
+		return "", errors.New("invalid token preface")
+	}
+	tok := make([]byte, buf[len(tokenMagic)])
+	if _, err := io.ReadFull(r, tok); err != nil {
+		return "", err
+	}
+	return string(tok), nil
+}
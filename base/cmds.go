@@ -17,6 +17,17 @@ func Close(conn net.Conn, session uint32) error {
 	return send(conn, buf)
 }
 
+//Refuse 关闭连接并说明原因（SESSION-ID后为原因文本）
+func Refuse(conn net.Conn, session uint32, reason string) error {
+	id := make([]byte, 4)
+	binary.BigEndian.PutUint32(id, session)
+	buf, err := Encode(ChunkCLS, append(id, reason...))
+	if err != nil {
+		return err
+	}
+	return send(conn, buf)
+}
+
 func Reply(conn net.Conn, session uint32, data []byte) error {
 	id := make([]byte, 4)
 	binary.BigEndian.PutUint32(id, session)
@@ -45,3 +56,22 @@ func Send(conn net.Conn, session uint32, data []byte) error {
 	}
 	return send(conn, buf)
 }
+
+//Respond 回复命令执行结果。结果可能超过MTU，因此分片发送：每片的第1字节为
+//命令代码，第2字节为结束标志（1表示最后一片），后续为结果数据
+func Respond(conn net.Conn, session uint32, code byte, data []byte) error {
+	const size = MaxData - 2 //扣除命令代码及结束标志
+	for {
+		n, last := len(data), byte(1)
+		if n > size {
+			n, last = size, 0
+		}
+		if err := Reply(conn, session, append([]byte{code, last}, data[:n]...)); err != nil {
+			return err
+		}
+		if last == 1 {
+			return nil
+		}
+		data = data[n:]
+	}
+}
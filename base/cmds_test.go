@@ -0,0 +1,104 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+//recvReply 读取Respond发送的全部分片，返回重组后的数据及分片数
+func recvReply(t *testing.T, conn net.Conn, session uint32, code byte) ([]byte, int, error) {
+	var data []byte
+	for n := 1; ; n++ {
+		ct, buf, err := Recv(conn)
+		if err != nil {
+			return data, n - 1, err
+		}
+		if ct != ChunkCMD || len(buf) < 6 {
+			t.Fatalf("unexpected chunk: type=%d, len=%d", ct, len(buf))
+		}
+		if sid := binary.BigEndian.Uint32(buf); sid != session {
+			t.Fatalf("session = %x, want %x", sid, session)
+		}
+		if buf[4] != code {
+			t.Fatalf("code = %d, want %d", buf[4], code)
+		}
+		data = append(data, buf[6:]...)
+		if buf[5] == 1 {
+			return data, n, nil
+		}
+	}
+}
+
+func TestRespond(t *testing.T) {
+	const size = MaxData - 2 //每片可携带的结果数据
+	cases := []struct {
+		len   int
+		parts int
+	}{
+		{0, 1},
+		{1, 1},
+		{size, 1},
+		{size + 1, 2},
+		{MaxData, 2},
+		{MaxData + 1, 2},
+		{2 * size, 2},
+		{2*size + 1, 3},
+	}
+	for _, c := range cases {
+		data := make([]byte, c.len)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		a, b := net.Pipe()
+		errc := make(chan error, 1)
+		go func() {
+			errc <- Respond(a, 0x1234, 9, data)
+			a.Close() //Respond失败时使recvReply结束
+		}()
+		got, parts, rerr := recvReply(t, b, 0x1234, 9)
+		err := <-errc
+		b.Close()
+		if err != nil {
+			t.Errorf("len=%d: Respond: %v", c.len, err)
+			continue
+		}
+		if rerr != nil {
+			t.Errorf("len=%d: Recv: %v", c.len, rerr)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("len=%d: reassembled %d bytes, data mismatch", c.len, len(got))
+		}
+		if parts != c.parts {
+			t.Errorf("len=%d: %d parts, want %d", c.len, parts, c.parts)
+		}
+	}
+}
+
+func TestReplyMaxData(t *testing.T) {
+	cases := []struct {
+		len int
+		ok  bool
+	}{
+		{MaxData - 1, true},
+		{MaxData, true},
+		{MaxData + 1, false},
+	}
+	for _, c := range cases {
+		a, b := net.Pipe()
+		go func() {
+			Recv(b)
+		}()
+		err := Reply(a, 1, make([]byte, c.len))
+		if c.ok && err != nil {
+			t.Errorf("len=%d: %v", c.len, err)
+		}
+		if !c.ok && err != ErrInvalidChunk {
+			t.Errorf("len=%d: err = %v, want ErrInvalidChunk", c.len, err)
+		}
+		a.Close()
+		b.Close()
+	}
+}
@@ -23,16 +23,19 @@ const (
 	ChunkDAT ChunkType = 2    //数据传输
 	ChunkCMD ChunkType = 3    //系统命令
 	ChunkCON ChunkType = 4    //连接建立或清除（内部使用）
-	MTU                = 8192 //包头表示长度用了13bit（含2字节的包头）
+	MTU                = 8192 //包头表示长度用了13bit（含2字节的包头），实际最大长度为MTU-1
 	TIMEOUT            = 60   //目前都使用默认值60秒
 	backlog            = 1024 //最多缓存的包数，超过这个数字会丢包
 )
 
+//MaxData 数据包可携带的最大数据量（扣除包头及SESSION-ID）
+const MaxData = MTU - 1 - 2 - 4
+
 var ErrInvalidChunk = errors.New("chunk size exceeds MTU")
 
 func Encode(ct ChunkType, data []byte) ([]byte, error) {
 	clen := len(data) + 2
-	if clen > MTU {
+	if clen >= MTU {
 		return nil, ErrInvalidChunk
 	}
 	buf := make([]byte, 2)
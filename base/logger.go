@@ -43,6 +43,8 @@ func newLogger(path string, split, keep int) *stdLogger {
 }
 
 func (sl *stdLogger) setDebug(mode bool) {
+	sl.Lock()
+	defer sl.Unlock()
 	sl.dbgMode = mode
 }
 
@@ -159,7 +161,10 @@ func (sl *stdLogger) rotate() {
 }
 
 func (sl *stdLogger) dbg(format string, args ...interface{}) {
-	if sl.dbgMode {
+	sl.Lock()
+	mode := sl.dbgMode
+	sl.Unlock()
+	if mode {
 		sl.log(format, args...)
 	}
 }
@@ -184,21 +189,56 @@ func (sl *stdLogger) log(format string, args ...interface{}) {
 	sl.lines = append(sl.lines, msg...)
 }
 
-var sl *stdLogger
+var sl *stdLogger //未调用InitLogger（例如作为库使用）时为nil，LOG被丢弃
 
 func InitLogger(path string, split, keep int, dbg bool) {
 	sl = newLogger(path, split, keep)
 	sl.setDebug(dbg)
 }
 
+//SetDebug 运行时切换调试模式
+func SetDebug(dbg bool) {
+	if sl == nil {
+		return
+	}
+	sl.setDebug(dbg)
+}
+
+//Debugging 是否处于调试模式
+func Debugging() bool {
+	if sl == nil {
+		return false
+	}
+	sl.Lock()
+	defer sl.Unlock()
+	return sl.dbgMode
+}
+
+//LogPath 返回LOG文件目录（为空表示不写LOG文件）
+func LogPath() string {
+	if sl == nil {
+		return ""
+	}
+	return sl.path
+}
+
 func Dbg(format string, args ...interface{}) {
+	if sl == nil {
+		return
+	}
 	sl.dbg(format, args...)
 }
 
 func Err(format string, args ...interface{}) {
+	if sl == nil {
+		return
+	}
 	sl.err(format, args...)
 }
 
 func Log(format string, args ...interface{}) {
+	if sl == nil {
+		return
+	}
 	sl.log(format, args...)
 }
@@ -4,6 +4,7 @@ import (
 	"dk/ctrl"
 	"dk/serv"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -17,8 +18,10 @@ type config struct {
 	Debug   bool        `yaml:"debug"`
 	Gateway ctrl.Config `yaml:"gateway"`
 	Backend serv.Config `yaml:"backend"`
-	ULimit  uint64      `yaml:"ulimit" json:"ulimit"`
-	Logging struct {
+	//Backends 同一进程中运行的其他后端身份（可连接不同的控制端）
+	Backends []serv.Config `yaml:"backends,omitempty"`
+	ULimit   uint64        `yaml:"ulimit" json:"ulimit"`
+	Logging  struct {
 		Path  string `yaml:"path" json:"path"`
 		Split int    `yaml:"split" json:"split"`
 		Keep  int    `yaml:"keep" json:"keep"`
@@ -65,26 +68,47 @@ func loadConfig(fn string) {
 	cf.Mode = strings.ToLower(cf.Mode)
 	switch cf.Mode {
 	case "backend":
-		if !nr.MatchString(cf.Backend.Name) {
-			panic(fmt.Errorf("loadConfig: client.name must be 1~32 chars of alphanum, . or -"))
+		cf.loadBackend(&cf.Backend, "")
+		if cf.Backend.Consent.Listen == "" {
+			cf.Backend.Consent.Listen = "127.0.0.1:3536"
 		}
-		cf.Backend.Name = strings.ToLower(cf.Backend.Name)
-		if cf.Backend.CtrlPort <= 0 || cf.Backend.CtrlPort > 65535 {
-			cf.Backend.CtrlPort = 35350
-		}
-		if cf.Backend.ConnWait <= 0 || cf.Backend.ConnWait > 300 {
-			cf.Backend.ConnWait = 60
-		}
-		if cf.Backend.ScanTTL < 100 {
-			cf.Backend.ScanTTL = 1000
+		names := map[string]bool{cf.Backend.Name: true}
+		for i := range cf.Backends {
+			b := &cf.Backends[i]
+			cf.loadBackend(b, "-"+strings.ToLower(b.Name))
+			if names[b.Name] {
+				panic(fmt.Errorf("loadConfig: duplicate backend name `%s`", b.Name))
+			}
+			names[b.Name] = true
+			if b.Consent.Listen == "" { //其他后端身份的确认页面依次使用后续端口
+				b.Consent.Listen = fmt.Sprintf("127.0.0.1:%d", 3537+i)
+			}
 		}
-		if cf.Backend.ScanTTL > 5000 {
-			cf.Backend.ScanTTL = 5000
+		listens := make(map[string]string) //确认页面监听地址=>后端名称
+		for _, b := range append([]serv.Config{cf.Backend}, cf.Backends...) {
+			if !b.Consent.Enable {
+				continue
+			}
+			if n, ok := listens[b.Consent.Listen]; ok {
+				panic(fmt.Errorf("loadConfig: backend `%s` and `%s` use the same consent.listen `%s`", n, b.Name, b.Consent.Listen))
+			}
+			listens[b.Consent.Listen] = b.Name
 		}
 	case "gateway":
 		if cf.Gateway.MgmtPort <= 0 || cf.Gateway.MgmtPort > 65535 {
 			cf.Gateway.MgmtPort = 3535
 		}
+		if cf.Gateway.HTTPPort < 0 || cf.Gateway.HTTPPort > 65535 || cf.Gateway.HTTPPort == cf.Gateway.MgmtPort {
+			panic(fmt.Errorf("loadConfig: gateway.http_port must be 0 (disabled) or a port other than mgmt_port"))
+		}
+		if cf.Gateway.TLSCert == "" {
+			cf.Gateway.TLSCert = "dk.crt"
+		}
+		cf.Gateway.TLSCert = cf.absPath(cf.Gateway.TLSCert)
+		if cf.Gateway.TLSKey == "" {
+			cf.Gateway.TLSKey = "dk.key"
+		}
+		cf.Gateway.TLSKey = cf.absPath(cf.Gateway.TLSKey)
 		if cf.Gateway.ServPort <= 0 || cf.Gateway.ServPort > 65535 {
 			cf.Gateway.ServPort = 35350
 		}
@@ -97,12 +121,35 @@ func loadConfig(fn string) {
 		if cf.Gateway.MaxServes <= 0 || cf.Gateway.MaxServes > 99 {
 			cf.Gateway.MaxServes = 9
 		}
+		if tp := cf.Gateway.TokenPort; tp < 0 || tp > 65535 || tp > 0 && (tp == cf.Gateway.MgmtPort ||
+			tp == cf.Gateway.HTTPPort || tp >= cf.Gateway.ServPort && tp <= cf.Gateway.ServPort+cf.Gateway.MaxServes) {
+			panic(fmt.Errorf("loadConfig: gateway.token_port must be 0 (disabled) or a port other than " +
+				"mgmt_port, http_port and serv_port ~ serv_port+max_serves"))
+		}
 		if cf.Gateway.IdleClose <= 0 || cf.Gateway.IdleClose > 86400 {
 			cf.Gateway.IdleClose = 600
 		}
 		if cf.Gateway.AuthTime <= 0 || cf.Gateway.AuthTime > 86400 {
 			cf.Gateway.AuthTime = 3600
 		}
+		if cf.Gateway.TokenFile == "" {
+			cf.Gateway.TokenFile = "tokens.json"
+		}
+		cf.Gateway.TokenFile = cf.absPath(cf.Gateway.TokenFile)
+		if cf.Gateway.Audit == "" {
+			cf.Gateway.Audit = "gateway-audit.jsonl" //不能放在LOG目录中，否则会被LOG轮转删除
+		}
+		cf.Gateway.Audit = cf.absPath(cf.Gateway.Audit)
+		if cf.Gateway.AuditSize <= 0 {
+			cf.Gateway.AuditSize = 16 * 1024 * 1024
+		}
+		if cf.Gateway.AuditKeep <= 0 {
+			cf.Gateway.AuditKeep = 10
+		}
+		if cf.Gateway.OTPState == "" {
+			cf.Gateway.OTPState = "otp_state.json"
+		}
+		cf.Gateway.OTPState = cf.absPath(cf.Gateway.OTPState)
 		if cf.Gateway.OTPIssuer == "" {
 			cf.Gateway.OTPIssuer = "Door Keeper"
 		}
@@ -111,6 +158,43 @@ func loadConfig(fn string) {
 		} else {
 			cf.Gateway.Users = unifyMap("server.users", cf.Gateway.Users)
 		}
+		for i, a := range cf.Gateway.Admins {
+			a = strings.TrimSpace(strings.ToLower(a))
+			if _, ok := cf.Gateway.Users[a]; !ok {
+				panic(fmt.Errorf("loadConfig: gateway.admins: `%s` is not in gateway.users", a))
+			}
+			cf.Gateway.Admins[i] = a
+		}
+		for g, members := range cf.Gateway.Groups {
+			for i, m := range members {
+				m = strings.TrimSpace(strings.ToLower(m))
+				if _, ok := cf.Gateway.Users[m]; !ok {
+					panic(fmt.Errorf("loadConfig: gateway.groups.%s: `%s` is not in gateway.users", g, m))
+				}
+				members[i] = m
+			}
+		}
+		for k, p := range cf.Gateway.Policies {
+			if p == nil {
+				panic(fmt.Errorf("loadConfig: gateway.policies.%s is empty", k))
+			}
+			if strings.HasPrefix(k, "@") {
+				if _, ok := cf.Gateway.Groups[k[1:]]; !ok {
+					panic(fmt.Errorf("loadConfig: gateway.policies: group `%s` is not in gateway.groups", k[1:]))
+				}
+			} else if _, ok := cf.Gateway.Users[k]; !ok {
+				panic(fmt.Errorf("loadConfig: gateway.policies: `%s` is not in gateway.users", k))
+			}
+			if err := p.Compile(); err != nil {
+				panic(fmt.Errorf("loadConfig: gateway.policies.%s: %v", k, err))
+			}
+		}
+		if cf.Gateway.LockAfter <= 0 || cf.Gateway.LockAfter > 100 {
+			cf.Gateway.LockAfter = 5
+		}
+		if cf.Gateway.LockTime <= 0 || cf.Gateway.LockTime > 3600 {
+			cf.Gateway.LockTime = 30
+		}
 		if cf.Gateway.Auths == nil {
 			cf.Gateway.Auths = make(map[string]string)
 		} else {
@@ -138,3 +222,111 @@ func loadConfig(fn string) {
 		cf.Logging.Keep = 10 //最多保留10个LOG文件
 	}
 }
+
+//loadBackend 校验后端配置并设置文件路径，suffix用于区分多个后端身份的默认文件名
+func (c config) loadBackend(b *serv.Config, suffix string) {
+	if !nr.MatchString(b.Name) {
+		panic(fmt.Errorf("loadConfig: client.name must be 1~32 chars of alphanum, . or -"))
+	}
+	b.Name = strings.ToLower(b.Name)
+	if b.FileRoot != "" {
+		b.FileRoot = c.absPath(b.FileRoot)
+	}
+	if b.Audit == "" {
+		b.Audit = "audit" + suffix + ".jsonl" //不能放在LOG目录中，否则会被LOG轮转删除
+	}
+	b.Audit = c.absPath(b.Audit)
+	if b.Control == "" {
+		b.Control = "dk" + suffix + ".sock"
+	}
+	b.Control = c.absPath(b.Control)
+	//合并远程修改的配置（仅限仍允许远程修改的配置项）
+	if buf, err := ioutil.ReadFile(c.remoteFile(suffix)); err == nil {
+		var rc remoteConf
+		if err := yaml.Unmarshal(buf, &rc); err != nil {
+			panic(fmt.Errorf("loadConfig: %s: %v", c.remoteFile(suffix), err))
+		}
+		if rc.LanNets != nil && b.Remote["lan_nets"] {
+			b.LanNets = *rc.LanNets
+		}
+		if rc.ScanTTL != nil && b.Remote["scan_ttl"] {
+			b.ScanTTL = *rc.ScanTTL
+		}
+		b.ConfTime = rc.ConfTime
+	} else if !os.IsNotExist(err) {
+		panic(fmt.Errorf("loadConfig: %v", err))
+	}
+	for k, v := range b.Proxy {
+		v = strings.ToLower(v)
+		if v != "v1" && v != "v2" {
+			panic(fmt.Errorf("loadConfig: proxy_protocol must be v1 or v2 (invalid entry `%s`)", k))
+		}
+		b.Proxy[k] = v
+	}
+	b.Version = verinfo()
+	b.Persist = saveBackend
+	b.SelfUpdate = true
+}
+
+//identity 返回名为name的后端配置（name为空表示backend）
+func (c config) identity(name string) (serv.Config, error) {
+	name = strings.ToLower(name)
+	if name == "" || name == c.Backend.Name {
+		return c.Backend, nil
+	}
+	for _, b := range c.Backends {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return serv.Config{}, fmt.Errorf("backend `%s` not found", name)
+}
+
+//remoteFile 返回保存远程修改配置的文件。远程修改不写回配置文件本身，
+//以免丢失其中的注释及格式
+func (c config) remoteFile(suffix string) string {
+	return c.absPath("remote" + suffix + ".yaml")
+}
+
+//remoteConf 远程修改的配置项（未修改或不允许远程修改的项为nil）
+type remoteConf struct {
+	LanNets  *[]string `yaml:"lan_nets,omitempty"`
+	ScanTTL  *int      `yaml:"scan_ttl,omitempty"`
+	ConfTime int64     `yaml:"conf_time"` //最后接受的远程配置请求的时间戳，重启后仍可防止重放
+}
+
+//saveBackend 将允许远程修改的后端配置项及ConfTime保存到remoteFile，下次启动时合并
+func saveBackend(b serv.Config) error {
+	suffix := ""
+	if b.Name != cf.Backend.Name {
+		suffix = "-" + b.Name
+	}
+	rc := remoteConf{ConfTime: b.ConfTime}
+	if b.Remote["lan_nets"] {
+		rc.LanNets = &b.LanNets
+	}
+	if b.Remote["scan_ttl"] {
+		rc.ScanTTL = &b.ScanTTL
+	}
+	buf, err := yaml.Marshal(rc)
+	if err != nil {
+		return err
+	}
+	buf = append([]byte("# 控制端远程修改的配置，优先于配置文件中的设置，删除本文件即可恢复\n"), buf...)
+	fn := cf.remoteFile(suffix)
+	mode := os.FileMode(0640)
+	if fi, err := os.Stat(fn); err == nil { //沿用原有文件或配置文件的权限
+		mode = fi.Mode().Perm()
+	} else if fi, err := os.Stat(cf.file); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil { //WriteFile的权限受umask影响
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
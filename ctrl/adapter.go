@@ -14,17 +14,23 @@ type (
 	dkAdapter struct {
 		wire net.Listener
 		port uint16
-		auth map[string]*authReq //来源IP=>目标的映射
+		auth map[string]*authReq //来源网段（CIDR）=>目标的映射
 		used time.Time           //最后使用时间
 		sync.RWMutex
 	}
 	authReq struct {
-		from net.IP
-		name string
-		host net.IP
-		port uint16
-		time time.Time //过期时间
-		rply chan interface{}
+		from  net.IP     //申请者的IP
+		src   *net.IPNet //授权的来源网段（默认为申请者的IP）
+		name  string
+		host  net.IP
+		port  uint16
+		user  string    //申请授权的用户（本机以PID访问者为空）
+		time  time.Time //过期时间
+		op    int       //对已有授权的操作（authAll等），为0表示申请或查询授权
+		serv  uint16    //操作的授权所在的接入端口
+		secs  int       //延期的秒数
+		admin bool      //操作者是否为管理员（可以操作他人的授权）
+		rply  chan interface{}
 	}
 	dkAdapters struct {
 		up time.Time //上次清理AUTH的时间
@@ -33,16 +39,52 @@ type (
 	}
 )
 
+//getAuth 返回来源网段包含from的授权，有多个时取最具体（前缀最长）者
 func (da *dkAdapter) getAuth(from net.IP) *authReq {
 	da.RLock()
 	defer da.RUnlock()
-	return da.auth[from.String()]
+	var ar *authReq
+	for _, a := range da.auth {
+		if a.src.Contains(from) && time.Now().Before(a.time) {
+			if ar == nil || prefixLen(a.src) > prefixLen(ar.src) {
+				ar = a
+			}
+		}
+	}
+	return ar
 }
 
 func (da *dkAdapter) setAuth(a *authReq) {
 	da.Lock()
 	defer da.Unlock()
-	da.auth[a.from.String()] = a
+	da.auth[a.src.String()] = a
+}
+
+//findAuth 返回来源网段为src的授权；src为单个IP时也可以是包含它的授权
+func (da *dkAdapter) findAuth(src *net.IPNet) *authReq {
+	da.RLock()
+	a := da.auth[src.String()]
+	da.RUnlock()
+	if a == nil && prefixLen(src) == len(src.IP)*8 {
+		a = da.getAuth(src.IP)
+	}
+	return a
+}
+
+func (da *dkAdapter) delAuth(a *authReq) {
+	da.Lock()
+	defer da.Unlock()
+	delete(da.auth, a.src.String())
+}
+
+func (da *dkAdapter) listAuths() []*authReq {
+	da.RLock()
+	defer da.RUnlock()
+	var list []*authReq
+	for _, a := range da.auth {
+		list = append(list, a)
+	}
+	return list
 }
 
 func (da *dkAdapter) refreshAuths() {
@@ -69,14 +111,20 @@ func (da *dkAdapter) IsIdle() bool {
 }
 
 func (da *dkAdapter) Match(ar authReq) int {
-	d := da.getAuth(ar.from)
-	if d == nil {
-		return 0 //该接口没有与来源src匹配的授权
-	}
-	if d.name != ar.name || !d.host.Equal(ar.host) || d.port != ar.port {
-		return -1 //该接口与来源src匹配的授权与dst不符
+	da.RLock()
+	defer da.RUnlock()
+	for _, d := range da.auth {
+		if time.Now().After(d.time) || !overlaps(d.src, ar.src) {
+			continue
+		}
+		if d.name != ar.name || !d.host.Equal(ar.host) || d.port != ar.port {
+			return -1 //该接口与来源src重叠的授权与dst不符
+		}
+		if d.src.String() == ar.src.String() {
+			return 1 //找到授权匹配
+		}
 	}
-	return 1 //找到授权匹配
+	return 0 //该接口没有与来源src冲突的授权
 }
 
 func (da *dkAdapter) RequestConnection(conn net.Conn) {
@@ -95,6 +143,7 @@ func (da *dkAdapter) RequestConnection(conn net.Conn) {
 		session: rand.Uint32(),
 		backend: ar.name,
 		dest:    dest,
+		user:    ar.user,
 		conn:    conn,
 	}
 	da.Used()
@@ -111,7 +160,7 @@ func newAdapter(serv uint16, ar *authReq) (da *dkAdapter, err error) {
 	da = &dkAdapter{
 		wire: ln,
 		port: serv,
-		auth: map[string]*authReq{ar.from.String(): ar},
+		auth: map[string]*authReq{ar.src.String(): ar},
 		used: time.Now(),
 	}
 	go func() {
@@ -166,6 +215,10 @@ func initAdapterManager(cf Config) {
 		serv:
 			ar := <-das.ch
 			das.RefreshAuths()
+			if ar.op != 0 { //列出、撤销或延期授权
+				ar.rply <- das.manage(ar, cf)
+				continue
+			}
 			if ar.from == nil { //表示为adapter空闲超时关闭，需要剔除
 				delete(das.as, ar.port)
 				continue
@@ -175,12 +228,16 @@ func initAdapterManager(cf Config) {
 				for p, da := range das.as {
 					a := da.getAuth(ar.from)
 					if a != nil {
-						auths = append(auths, map[string]interface{}{
+						auth := map[string]interface{}{
 							"port":  p,
 							"site":  a.name,
 							"addr":  fmt.Sprintf("%s:%d", a.host, a.port),
 							"until": a.time.Format(time.RFC3339),
-						})
+						}
+						if st := consentOf(ar.from, a.name, a.host, a.port); st != "" {
+							auth["consent"] = st //后端本地用户对连接的确认状态
+						}
+						auths = append(auths, auth)
 					}
 				}
 				ar.rply <- auths
@@ -230,7 +287,7 @@ func initAdapterManager(cf Config) {
 						das.as[p] = na
 						ar.rply <- int(p)
 					} else {
-						base.Log("newAdapter(%d, %s): %v", p, ar.from, err)
+						base.Log("newAdapter(%d, %s): %v", p, ar.src, err)
 						ar.rply <- 0 //创建新接口失败
 					}
 					break
@@ -0,0 +1,68 @@
+package ctrl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+//testAuth 创建来源为src、目标为site/host:port的授权，life为负表示已过期
+func testAuth(t *testing.T, src, site, host string, port uint16, life time.Duration) *authReq {
+	n, err := sourceNet(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authReq{src: n, name: site, host: net.ParseIP(host), port: port, time: time.Now().Add(life)}
+}
+
+func TestGetAuth(t *testing.T) {
+	da := &dkAdapter{auth: make(map[string]*authReq)}
+	da.setAuth(testAuth(t, "10.0.0.0/16", "a", "192.168.1.1", 22, time.Hour))
+	da.setAuth(testAuth(t, "10.0.1.0/24", "b", "192.168.1.1", 22, time.Hour))
+	da.setAuth(testAuth(t, "10.0.1.5", "c", "192.168.1.1", 22, -time.Second))
+	cases := []struct {
+		from string
+		want string //授权的后端，为空表示没有授权
+	}{
+		{"10.0.1.5", "b"}, //最具体的c已过期
+		{"10.0.1.7", "b"},
+		{"10.0.2.1", "a"},
+		{"11.0.0.1", ""},
+	}
+	for _, c := range cases {
+		ar := da.getAuth(net.ParseIP(c.from))
+		got := ""
+		if ar != nil {
+			got = ar.name
+		}
+		if got != c.want {
+			t.Errorf("getAuth(%s) = %q, want %q", c.from, got, c.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	da := &dkAdapter{auth: make(map[string]*authReq)}
+	da.setAuth(testAuth(t, "10.0.1.0/24", "s1", "192.168.1.10", 22, time.Hour))
+	da.setAuth(testAuth(t, "10.0.9.0/24", "s1", "192.168.1.10", 80, -time.Second))
+	cases := []struct {
+		src, site, host string
+		port            uint16
+		want            int
+	}{
+		{"10.0.1.0/24", "s1", "192.168.1.10", 22, 1},
+		{"10.0.1.0/24", "s1", "192.168.1.10", 23, -1},
+		{"10.0.1.0/24", "s2", "192.168.1.10", 22, -1},
+		{"10.0.1.5", "s1", "192.168.1.10", 22, 0}, //包含于已有授权，目标相同
+		{"10.0.1.5", "s1", "192.168.1.11", 22, -1},
+		{"10.0.0.0/16", "s1", "192.168.1.11", 22, -1}, //包含已有授权
+		{"10.0.2.0/24", "s2", "192.168.1.11", 22, 0},
+		{"10.0.9.1", "s2", "192.168.1.11", 22, 0}, //重叠的授权已过期
+	}
+	for _, c := range cases {
+		got := da.Match(*testAuth(t, c.src, c.site, c.host, c.port, time.Hour))
+		if got != c.want {
+			t.Errorf("Match(%s => %s/%s:%d) = %d, want %d", c.src, c.site, c.host, c.port, got, c.want)
+		}
+	}
+}
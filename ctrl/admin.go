@@ -1,7 +1,11 @@
 package ctrl
 
 import (
+	"context"
+	"crypto/tls"
+	"dk/base"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -9,9 +13,47 @@ import (
 
 const httpSvrTimeout = time.Minute
 
+type (
+	connKey   struct{}
+	aliveBody struct{ r *http.Request } //每次读取都延长连接期限的请求体
+)
+
+//keepAlive 将请求所在连接的读写期限延长至d+httpSvrTimeout之后（d为处理本身
+//所需的时间），用于等待时间或传输量超出httpSvrTimeout的请求。go1.15没有
+//http.ResponseController，故由ConnContext保存连接并直接设置其期限
+func keepAlive(r *http.Request, d time.Duration) {
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(d + httpSvrTimeout))
+	}
+}
+
+func (b aliveBody) Read(p []byte) (int, error) {
+	keepAlive(b.r, 0)
+	return b.r.Body.Read(p)
+}
+
+func (b aliveBody) Close() error {
+	return b.r.Body.Close()
+}
+
 func startAdminInterface(cf Config) {
 	setEnv(cf)
 	setWatchdog(cf)
+	cert, err := loadCert(cf)
+	assert(err)
+	fmt.Printf("management certificate SHA-256 fingerprint: %s\n", fingerprint(cert))
+	base.Log("management certificate: %s (SHA-256 %s)", cf.TLSCert, fingerprint(cert))
+	if cf.HTTPPort > 0 {
+		go func() {
+			svr := http.Server{
+				Addr:         fmt.Sprintf(":%d", cf.HTTPPort),
+				Handler:      redirectHTTPS(cf.MgmtPort),
+				ReadTimeout:  httpSvrTimeout,
+				WriteTimeout: httpSvrTimeout,
+			}
+			base.Log("redirect: %v", svr.ListenAndServe())
+		}()
+	}
 	go func() {
 		defer func() {
 			fmt.Fprintf(os.Stderr, "startAdminInterface: %v", recover())
@@ -22,7 +64,17 @@ func startAdminInterface(cf Config) {
 			Addr:         fmt.Sprintf(":%d", cf.MgmtPort),
 			ReadTimeout:  httpSvrTimeout,
 			WriteTimeout: httpSvrTimeout,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return context.WithValue(ctx, connKey{}, c)
+			},
+			//HTTP/2的多个请求共用一个连接且自行计时，keepAlive无法按请求延长期限，
+			//故管理接口只使用HTTP/1.1
+			TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+			},
 		}
-		assert(svr.ListenAndServe())
+		assert(svr.ListenAndServeTLS("", ""))
 	}()
 }
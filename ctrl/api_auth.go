@@ -1,36 +1,118 @@
 package ctrl
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func apiAuth(w http.ResponseWriter, r *http.Request) {
-	if !allowed(r) {
-		return
-	}
-	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	ip := net.ParseIP(host)
-	if ip == nil {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "bad request",
-		})
-		return
-	}
-	ch := make(chan interface{})
-	das.ch <- authReq{from: ip, port: 0, rply: ch}
-	select {
-	case rep := <-ch:
-		jsonReply(w, map[string]interface{}{
-			"stat": true,
-			"data": rep,
-		})
-	case <-time.After(chanLife):
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "no reply",
-		})
+//apiAuth 查询当前IP的有效授权（GET /dk/auth，管理员可用all=1查询所有授权），
+//撤销（DELETE /dk/auth/<port>）或延期（POST /dk/auth/<port>[?secs=<n>]）授权。
+//from=<ip或cidr>指定授权的来源（默认为当前IP）
+func apiAuth(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "bad request",
+			})
+			return
+		}
+		q := r.URL.Query()
+		admin := isAdmin(cf, usr)
+		ch := make(chan interface{}, 1)
+		ar := authReq{from: ip, src: hostNet(ip), port: 0, user: usr, admin: admin, rply: ch}
+		arg := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/dk/auth"), "/")
+		switch {
+		case arg == "" && q.Get("all") == "1":
+			if !admin {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "admin only",
+				})
+				return
+			}
+			ar.op = authAll
+		case arg != "":
+			port, _ := strconv.Atoi(arg)
+			if port <= 0 || port > 65535 {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", arg),
+				})
+				return
+			}
+			ar.serv = uint16(port)
+			if f := q.Get("from"); f != "" { //非管理员只能操作自己申请的授权
+				src, err := parseNet(f)
+				if err != nil {
+					jsonReply(w, map[string]interface{}{"stat": false, "mesg": err.Error()})
+					return
+				}
+				ar.src = src
+			}
+			switch r.Method {
+			case "DELETE":
+				ar.op = authRevoke
+			case "POST":
+				ar.op = authExtend
+				ar.secs, _ = strconv.Atoi(q.Get("secs"))
+			default:
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "DELETE or POST expected",
+				})
+				return
+			}
+			if cf.TokenPort > 0 && port == cf.TokenPort { //令牌授权，以id指定
+				id := q.Get("id")
+				if id == "" {
+					jsonReply(w, map[string]interface{}{
+						"stat": false,
+						"mesg": "id of token auth expected",
+					})
+					return
+				}
+				jsonReply(w, manageGrant(ar, id, cf))
+				return
+			}
+		}
+		das.ch <- ar
+		select {
+		case rep := <-ch:
+			if cf.TokenPort > 0 { //附加令牌授权
+				switch ar.op {
+				case 0:
+					rep = append(rep.([]map[string]interface{}),
+						listGrants(cf.TokenPort, usr, false)...)
+				case authAll:
+					r := rep.(map[string]interface{})
+					r["data"] = append(r["data"].([]map[string]interface{}),
+						listGrants(cf.TokenPort, usr, true)...)
+				}
+			}
+			if ar.op != 0 {
+				jsonReply(w, rep)
+				return
+			}
+			jsonReply(w, map[string]interface{}{
+				"stat": true,
+				"data": rep,
+			})
+		case <-time.After(chanLife):
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "no reply",
+			})
+		}
 	}
 }
@@ -0,0 +1,75 @@
+package ctrl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minHookCode = 64  //后端自定义命令的最小代码（更小的代码为DK内置命令）
+	maxHookWait = 300 //等待自定义命令回复的最长时间（秒）
+)
+
+//apiCmd 调用后端以serv.Client.Handle注册的自定义命令。GET以查询参数（timeout及鉴权参数除外）
+//作为命令参数，POST以请求体（JSON）作为命令参数
+func apiCmd(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(r.URL.Path[8:], "/")
+		if len(p) != 2 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/code expected",
+			})
+			return
+		}
+		if _, ok := siteUser(w, r, cf, p[0], true); !ok {
+			return
+		}
+		code, err := strconv.Atoi(p[1])
+		if err != nil || code < minHookCode || code > 255 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid command code '%s' (%d~255)", p[1], minHookCode),
+			})
+			return
+		}
+		q := r.URL.Query()
+		life := chanLife
+		if wait, _ := strconv.Atoi(q.Get("timeout")); wait > 0 && wait <= maxHookWait {
+			life = time.Duration(wait) * time.Second
+		}
+		var args interface{}
+		switch r.Method {
+		case "GET":
+			m := make(map[string]string)
+			for k := range q {
+				if k != "timeout" && k != "u" && k != "p" && k != "t" {
+					m[k] = q.Get(k)
+				}
+			}
+			args = m
+		case "POST":
+			body, err := ioutil.ReadAll(r.Body)
+			if err == nil && len(body) > 0 && !json.Valid(body) {
+				err = fmt.Errorf("request body is not valid JSON")
+			}
+			if err != nil {
+				jsonReply(w, map[string]interface{}{"stat": false, "mesg": err.Error()})
+				return
+			}
+			if len(body) > 0 {
+				args = json.RawMessage(body)
+			}
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		keepAlive(r, life)
+		jsonReply(w, cmdReply(callBackend(p[0], byte(code), args, life)))
+	}
+}
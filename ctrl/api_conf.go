@@ -0,0 +1,57 @@
+package ctrl
+
+import (
+	"dk/base"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//apiConf 查询（GET）或修改（POST，JSON格式的配置项）后端的可远程修改配置
+func apiConf(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[9:]
+		if _, ok := siteUser(w, r, cf, name, true); !ok {
+			return
+		}
+		key, ok := cf.Auths[name]
+		if !ok {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("backend '%s' not found", name),
+			})
+			return
+		}
+		settings := make(map[string]json.RawMessage)
+		if r.Method == "POST" {
+			if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("invalid settings: %v", err),
+				})
+				return
+			}
+			if len(settings) == 0 {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "no setting given",
+				})
+				return
+			}
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"time":     time.Now().UnixNano(),
+			"settings": settings,
+		})
+		args := map[string]interface{}{
+			"conf": string(body),
+			"sign": hex.EncodeToString(base.Sign(base.SubKey(key, "conf"), body)),
+		}
+		if len(settings) > 0 {
+			base.Log("push configuration to %s: %s", name, body)
+		}
+		jsonReply(w, cmdReply(callBackend(name, 5, args, chanLife)))
+	}
+}
@@ -1,6 +1,7 @@
 package ctrl
 
 import (
+	"dk/base"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,80 +10,127 @@ import (
 	"time"
 )
 
-func apiConn(w http.ResponseWriter, r *http.Request) {
-	if !allowed(r) {
-		return
-	}
-	p := strings.Split(r.URL.Path[9:], "/")
-	if len(p) < 2 || len(p) > 3 {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "name/port[/ip] expected",
-		})
-		return
-	}
-	name := p[0]
-	port, _ := strconv.Atoi(p[1])
-	if port <= 0 || port > 65535 {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", p[1]),
-		})
-		return
-	}
-	host := net.ParseIP("127.0.0.1")
-	if len(p) == 3 && len(p[2]) > 0 {
-		ip := net.ParseIP(p[2])
+func apiConn(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		p := strings.Split(r.URL.Path[9:], "/")
+		if len(p) < 2 || len(p) > 3 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/port[/ip] expected",
+			})
+			return
+		}
+		name := p[0]
+		port, _ := strconv.Atoi(p[1])
+		if port <= 0 || port > 65535 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", p[1]),
+			})
+			return
+		}
+		host := net.ParseIP("127.0.0.1")
+		if len(p) == 3 && len(p[2]) > 0 {
+			ip := net.ParseIP(p[2])
+			if ip == nil {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("host '%s' is not valid IP", p[2]),
+				})
+				return
+			}
+			host = ip
+		}
+		rip, _, _ := net.SplitHostPort(r.RemoteAddr)
+		dest := net.JoinHostPort(host.String(), strconv.Itoa(port))
+		ar := auditRec{Event: "auth", User: usr, From: rip, Site: name, Dest: dest}
+		if !cf.permitConn(usr, name, host, uint16(port)) {
+			base.Log("conn %s:%s:%d by %s denied by policy", name, host, port, usr)
+			ar.Mesg = "denied by policy"
+			audit(ar)
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "access denied by policy",
+			})
+			return
+		}
+		ip := net.ParseIP(rip)
 		if ip == nil {
 			jsonReply(w, map[string]interface{}{
 				"stat": false,
-				"mesg": fmt.Sprintf("host '%s' is not valid IP", p[2]),
+				"mesg": fmt.Sprintf("failed to parse remote addr '%s'", r.RemoteAddr),
 			})
 			return
 		}
-		host = ip
-	}
-	rip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	ip := net.ParseIP(rip)
-	if ip == nil {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": fmt.Sprintf("failed to parse remote addr '%s'", r.RemoteAddr),
-		})
-		return
-	}
-	ch := make(chan interface{})
-	das.ch <- authReq{
-		from: ip,
-		name: name,
-		host: host,
-		port: uint16(port),
-		rply: ch,
-	}
-	select {
-	case rep := <-ch:
-		if rep.(int) <= 0 {
-			var mesg string
-			switch rep {
-			case 0: //创建新接口失败（服务器容量满或发生其它错误）
-				mesg = "create adapter failed"
-			case -1: //查询后端超时
-				mesg = "query backend timeout"
-			case -2: //找不到名字为name的后端
-				mesg = "no such backend: " + name
+		src := hostNet(ip)
+		if f := r.URL.Query().Get("from"); f != "" { //为其他来源（IP或CIDR）申请授权
+			var err error
+			if src, err = sourceNet(f); err != nil {
+				jsonReply(w, map[string]interface{}{"stat": false, "mesg": err.Error()})
+				return
 			}
-			jsonReply(w, map[string]interface{}{"stat": false, "mesg": mesg})
+			ar.Source = src.String()
+			if !cf.permitSource(usr, src) {
+				base.Log("conn %s:%s:%d from %s by %s denied by policy", name, host, port, src, usr)
+				ar.Mesg = "source denied by policy"
+				audit(ar)
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "source denied by policy",
+				})
+				return
+			}
+		}
+		if r.URL.Query().Get("token") == "1" { //经共享的令牌端口接入
+			grantToken(w, cf, ar, authReq{from: ip, src: src, name: name, host: host,
+				port: uint16(port), user: usr})
 			return
 		}
-		jsonReply(w, map[string]interface{}{
-			"stat": true,
-			"data": rep,
-			"mesg": fmt.Sprintf("connect to port %d", rep),
-		})
-	case <-time.After(chanLife):
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "no reply",
-		})
+		ch := make(chan interface{})
+		das.ch <- authReq{
+			from: ip,
+			src:  src,
+			name: name,
+			host: host,
+			port: uint16(port),
+			user: usr,
+			rply: ch,
+		}
+		select {
+		case rep := <-ch:
+			if rep.(int) <= 0 {
+				var mesg string
+				switch rep {
+				case 0: //创建新接口失败（服务器容量满或发生其它错误）
+					mesg = "create adapter failed"
+				case -1: //查询后端超时
+					mesg = "query backend timeout"
+				case -2: //找不到名字为name的后端
+					mesg = "no such backend: " + name
+				}
+				ar.Mesg = mesg
+				audit(ar)
+				jsonReply(w, map[string]interface{}{"stat": false, "mesg": mesg})
+				return
+			}
+			ar.OK, ar.Port = true, rep.(int)
+			audit(ar)
+			jsonReply(w, map[string]interface{}{
+				"stat": true,
+				"data": rep,
+				"mesg": fmt.Sprintf("connect to port %d", rep),
+			})
+		case <-time.After(chanLife):
+			ar.Mesg = "no reply"
+			audit(ar)
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "no reply",
+			})
+		}
 	}
 }
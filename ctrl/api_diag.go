@@ -0,0 +1,81 @@
+package ctrl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func apiDiag(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(r.URL.Path[9:], "/")
+		if len(p) != 2 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/type expected",
+			})
+			return
+		}
+		usr, ok := siteUser(w, r, cf, p[0], false)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		args := map[string]interface{}{"type": p[1]}
+		switch p[1] {
+		case "tcp", "trace":
+			port, _ := strconv.Atoi(q.Get("port"))
+			if port <= 0 || port > 65535 {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", q.Get("port")),
+				})
+				return
+			}
+			host := q.Get("host")
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			if !cf.permitConn(usr, p[0], net.ParseIP(host), uint16(port)) { //有目标限制时host须为IP
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "access denied by policy",
+				})
+				return
+			}
+			args["host"] = host
+			args["port"] = port
+			if ttl, _ := strconv.Atoi(q.Get("ttl")); ttl > 0 {
+				args["max_ttl"] = ttl
+			}
+		case "dns":
+			name := q.Get("name")
+			if name == "" {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "missing parameter 'name'",
+				})
+				return
+			}
+			args["host"] = name
+		case "netinfo":
+		default:
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid type '%s', tcp/dns/trace/netinfo expected", p[1]),
+			})
+			return
+		}
+		life := chanLife
+		if to, _ := strconv.Atoi(q.Get("timeout")); to > 0 && to <= 5000 {
+			args["timeout"] = to
+			life += time.Duration(to) * time.Millisecond
+		} else {
+			life += 3 * time.Second //后端默认超时为3秒
+		}
+		jsonReply(w, cmdReply(callBackend(p[0], 3, args, life)))
+	}
+}
@@ -0,0 +1,58 @@
+package ctrl
+
+import (
+	"dk/base"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//apiExec 列出后端允许执行的命令，或执行其中之一并以流式（JSON lines）返回
+//其标准输出、标准错误及退出码
+func apiExec(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(r.URL.Path[9:], "/")
+		if len(p) > 2 || p[0] == "" {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name[/command] expected",
+			})
+			return
+		}
+		if _, ok := siteUser(w, r, cf, p[0], true); !ok {
+			return
+		}
+		if len(p) == 1 {
+			jsonReply(w, cmdReply(callBackend(p[0], 9, map[string]interface{}{}, chanLife)))
+			return
+		}
+		timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+		if timeout <= 0 || timeout > 300 {
+			timeout = 30
+		}
+		args := map[string]interface{}{"name": p[1], "timeout": timeout}
+		life := chanLife + time.Duration(timeout)*time.Second
+		keepAlive(r, life)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Add("Cache-Control", "no-store")
+		flusher, _ := w.(http.Flusher)
+		var started bool
+		err := streamBackend(p[0], 9, args, life, func(part []byte) error {
+			started = true
+			if _, err := w.Write(append(part, '\n')); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			base.Log("apiExec(%s/%s): %v", p[0], p[1], err)
+			if !started {
+				jsonReply(w, cmdReply(nil, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package ctrl
+
+import (
+	"dk/base"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//apiFile 在后端的backend.file_root目录中上传（PUT/POST）、下载（GET）文件，
+//或列出目录（op=list）、查看文件信息（op=stat）。上传和下载均可通过offset
+//参数续传（下载也支持Range请求头）
+func apiFile(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.SplitN(r.URL.Path[9:], "/", 2)
+		if p[0] == "" {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/path expected",
+			})
+			return
+		}
+		if _, ok := siteUser(w, r, cf, p[0], true); !ok {
+			return
+		}
+		var fp string
+		if len(p) == 2 {
+			fp = p[1]
+		}
+		q := r.URL.Query()
+		offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+		if offset < 0 {
+			offset = 0
+		}
+		switch r.Method {
+		case "PUT", "POST":
+			uploadFile(w, r, p[0], fp, offset)
+			return
+		}
+		switch op := q.Get("op"); op {
+		case "list", "stat":
+			jsonReply(w, cmdReply(callBackend(p[0], 10, map[string]interface{}{
+				"op":   op,
+				"path": fp,
+			}, chanLife)))
+		case "":
+			if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") &&
+				strings.HasSuffix(rng, "-") {
+				offset, _ = strconv.ParseInt(rng[6:len(rng)-1], 10, 64)
+			}
+			downloadFile(w, r, p[0], fp, offset)
+		default:
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid op '%s', list/stat expected", op),
+			})
+		}
+	}
+}
+
+func downloadFile(w http.ResponseWriter, r *http.Request, site, path string, offset int64) {
+	var x *xferStat
+	for start := offset; ; {
+		rep, err := callBackend(site, 10, map[string]interface{}{
+			"op":     "get",
+			"path":   path,
+			"offset": offset,
+		}, chanLife)
+		if err == nil && rep["stat"] != true {
+			err = fmt.Errorf("%v", rep["mesg"])
+		}
+		if err != nil {
+			if x == nil {
+				jsonReply(w, cmdReply(nil, err))
+				return
+			}
+			x.finish(err)
+			base.Log("download(%s:%s): %v", site, path, err)
+			panic(http.ErrAbortHandler) //已开始传输，只能中断连接
+		}
+		data := rep["data"].(map[string]interface{})
+		buf, _ := base64.StdEncoding.DecodeString(data["data"].(string))
+		sz, _ := data["size"].(float64)
+		size := int64(sz)
+		if x == nil {
+			if offset > size {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("offset %d beyond file size %d", offset, size),
+				})
+				return
+			}
+			x = newXfer(site, path, "down", size, offset)
+			base.Log("download(%s:%s): offset=%d, size=%d", site, path, offset, size)
+			name := path[strings.LastIndex(path, "/")+1:]
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.FormatInt(size-start, 10))
+			if start > 0 {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, size-1, size))
+				w.WriteHeader(http.StatusPartialContent)
+			}
+		}
+		if int64(len(buf)) > x.Size-offset {
+			buf = buf[:x.Size-offset] //文件在读取期间增长，以首次读取时的大小为准
+		}
+		keepAlive(r, 0)
+		if _, err := w.Write(buf); err != nil {
+			x.finish(err)
+			return
+		}
+		offset += int64(len(buf))
+		x.progress(offset)
+		if len(buf) == 0 || offset >= x.Size {
+			x.finish(nil)
+			return
+		}
+	}
+}
+
+func uploadFile(w http.ResponseWriter, r *http.Request, site, path string, offset int64) {
+	fail := func(err error) {
+		jsonReply(w, map[string]interface{}{"stat": false, "mesg": err.Error()})
+	}
+	call := func(args map[string]interface{}) (int64, error) {
+		rep, err := callBackend(site, 10, args, chanLife)
+		if err != nil {
+			return 0, err
+		}
+		if rep["stat"] != true {
+			return 0, fmt.Errorf("%v", rep["mesg"])
+		}
+		size, _ := rep["data"].(map[string]interface{})["size"].(float64)
+		return int64(size), nil
+	}
+	handle := rand.Uint32()
+	_, err := call(map[string]interface{}{
+		"op":     "open",
+		"path":   path,
+		"offset": offset,
+		"handle": handle,
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	conn, err := backendConn(site)
+	if err != nil {
+		fail(err)
+		return
+	}
+	size := int64(-1) //未知大小
+	if r.ContentLength >= 0 {
+		size = offset + r.ContentLength
+	}
+	x := newXfer(site, path, "up", size, offset)
+	base.Log("upload(%s:%s): offset=%d, size=%d", site, path, offset, r.ContentLength)
+	err = func() error {
+		buf := make([]byte, 13+dataChunk)
+		buf[0] = 11
+		binary.BigEndian.PutUint32(buf[1:], handle)
+		for i := 1; ; i++ {
+			n, err := io.ReadFull(aliveBody{r}, buf[13:])
+			if n > 0 {
+				binary.BigEndian.PutUint64(buf[5:], uint64(offset))
+				if err := base.Reply(conn, 0, buf[:13+n]); err != nil {
+					return err
+				}
+				offset += int64(n)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if i%updSync == 0 {
+				done, err := call(map[string]interface{}{"op": "sync", "handle": handle})
+				if err != nil {
+					return err
+				}
+				x.progress(done)
+			}
+		}
+	}()
+	//即使上传中断，也需关闭文件，已收到的部分可用于续传
+	var cerr error
+	size, cerr = call(map[string]interface{}{"op": "close", "handle": handle})
+	if err == nil {
+		err = cerr
+	}
+	x.progress(size)
+	x.finish(err)
+	if err != nil {
+		base.Log("upload(%s:%s): %v", site, path, err)
+		fail(err)
+		return
+	}
+	jsonReply(w, map[string]interface{}{
+		"stat": true,
+		"data": map[string]interface{}{"size": size},
+	})
+}
+
+func apiXfer(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		list := []xferStat{}
+		for _, x := range listXfers() { //只列出用户可管理的后端的传输
+			if cf.permitManage(usr, x.Site) {
+				list = append(list, x)
+			}
+		}
+		jsonReply(w, map[string]interface{}{
+			"stat": true,
+			"data": list,
+		})
+	}
+}
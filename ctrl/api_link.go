@@ -0,0 +1,49 @@
+package ctrl
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//apiLink 查看后端最近一次的链路测试结果，或进行新的测试（run=1）
+func apiLink(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[9:]
+		if _, ok := siteUser(w, r, cf, name, false); !ok {
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("run") == "" {
+			ls := lastLinkStat(name)
+			if ls == nil {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "no link test result for " + name,
+				})
+				return
+			}
+			jsonReply(w, map[string]interface{}{"stat": true, "data": ls})
+			return
+		}
+		opts := linkOpts{count: 20, size: 1024 * 1024, duration: 5}
+		if c, _ := strconv.Atoi(q.Get("count")); c > 0 && c <= 100 {
+			opts.count = c
+		}
+		if s, _ := strconv.ParseInt(q.Get("size"), 10, 64); s > 0 && s <= 64*1024*1024 {
+			opts.size = s
+		}
+		if d, _ := strconv.Atoi(q.Get("duration")); d > 0 && d <= 30 {
+			opts.duration = d
+		}
+		//最长耗时：每次回显3秒，上下行各duration秒加chanLife
+		keepAlive(r, time.Duration(opts.count)*3*time.Second+
+			2*(chanLife+time.Duration(opts.duration)*time.Second))
+		ls := testLink(name, opts)
+		jsonReply(w, map[string]interface{}{
+			"stat": ls.Error == "",
+			"mesg": ls.Error,
+			"data": ls,
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package ctrl
+
+import (
+	"dk/base"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//apiLockout 管理员查看（GET /dk/lockout）或清除（DELETE /dk/lockout[/<ip或用户>]）登录失败锁定
+func apiLockout(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		if !isAdmin(cf, usr) {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "admin only",
+			})
+			return
+		}
+		if r.Method != "DELETE" {
+			jsonReply(w, map[string]interface{}{
+				"stat": true,
+				"data": LO.List(),
+			})
+			return
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/dk/lockout"), "/")
+		if !LO.Clear(key) {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("'%s' not found", key),
+			})
+			return
+		}
+		if key == "" {
+			key = "all"
+		}
+		if usr == "" {
+			usr = "local user"
+		}
+		base.Log("login: lockout of %s cleared by %s", key, usr)
+		jsonReply(w, map[string]interface{}{"stat": true})
+	}
+}
@@ -0,0 +1,85 @@
+package ctrl
+
+import (
+	"dk/base"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//apiLog 查看后端的LOG文件列表、切换调试模式、查看或下载LOG文件
+func apiLog(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(r.URL.Path[8:], "/")
+		if len(p) > 2 || p[0] == "" {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name[/file] expected",
+			})
+			return
+		}
+		if _, ok := siteUser(w, r, cf, p[0], false); !ok {
+			return
+		}
+		q := r.URL.Query()
+		if len(p) == 1 {
+			args := map[string]interface{}{"op": "list"}
+			if dbg := q.Get("debug"); dbg != "" {
+				args["op"] = "debug"
+				args["debug"] = dbg == "1" || dbg == "true"
+			}
+			jsonReply(w, cmdReply(callBackend(p[0], 8, args, chanLife)))
+			return
+		}
+		if tail := q.Get("tail"); tail != "" {
+			lines, _ := strconv.Atoi(tail)
+			jsonReply(w, cmdReply(callBackend(p[0], 8, map[string]interface{}{
+				"op":    "tail",
+				"file":  p[1],
+				"lines": lines,
+			}, chanLife)))
+			return
+		}
+		var offset, size int64
+		for {
+			rep, err := callBackend(p[0], 8, map[string]interface{}{
+				"op":     "get",
+				"file":   p[1],
+				"offset": offset,
+			}, chanLife)
+			if err == nil && rep["stat"] != true {
+				err = fmt.Errorf("%v", rep["mesg"])
+			}
+			if err != nil {
+				if offset > 0 { //已开始传输，只能中断连接
+					base.Log("apiLog(%s/%s): %v", p[0], p[1], err)
+					panic(http.ErrAbortHandler)
+				}
+				jsonReply(w, cmdReply(nil, err))
+				return
+			}
+			data := rep["data"].(map[string]interface{})
+			buf, _ := base64.StdEncoding.DecodeString(data["data"].(string))
+			if offset == 0 {
+				sz, _ := data["size"].(float64)
+				size = int64(sz)
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s"`, p[0], p[1]))
+				w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+			if int64(len(buf)) > size-offset {
+				buf = buf[:size-offset] //文件在读取期间增长，以首次读取时的大小为准
+			}
+			keepAlive(r, 0)
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			offset += int64(len(buf))
+			if len(buf) == 0 || offset >= size {
+				return
+			}
+		}
+	}
+}
@@ -8,7 +8,7 @@ import (
 func apiLogin(cf Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if usr, ok := authChk(r, cf); ok {
-			tok, exp := TS.Set(r)
+			tok, exp := TS.Set(r, usr)
 			setCookie(w, "t", tok, cf.AuthTime)
 			if usr != "" {
 				setCookie(w, "u", usr, 365*86400)
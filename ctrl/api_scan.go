@@ -1,42 +1,82 @@
 package ctrl
 
 import (
+	"dk/base"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func apiScan(w http.ResponseWriter, r *http.Request) {
-	if !allowed(r) {
-		return
-	}
-	p := strings.SplitN(r.URL.Path[9:], "/", 2)
-	if len(p) != 2 {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "name/port expected",
-		})
-		return
-	}
-	port, _ := strconv.Atoi(p[1])
-	if port <= 0 || port > 65535 {
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", p[1]),
-		})
-		return
-	}
-	ch := make(chan interface{})
-	br <- reqScan{name: p[0], port: uint16(port), rep: ch}
-	select {
-	case rep := <-ch:
-		jsonReply(w, rep)
-	case <-time.After(chanLife):
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "no reply",
-		})
+func apiScan(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		p := strings.SplitN(r.URL.Path[9:], "/", 2)
+		if len(p) != 2 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/port expected",
+			})
+			return
+		}
+		port, _ := strconv.Atoi(p[1])
+		if port <= 0 || port > 65535 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid port '%s', 1~65535 expected", p[1]),
+			})
+			return
+		}
+		rip, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ar := auditRec{Event: "scan", User: usr, From: rip, Site: p[0], Dest: ":" + p[1]}
+		if !cf.permitScan(usr, p[0], uint16(port)) {
+			base.Log("scan %s:%d by %s denied by policy", p[0], port, usr)
+			ar.Mesg = "denied by policy"
+			audit(ar)
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "access denied by policy",
+			})
+			return
+		}
+		ch := make(chan interface{})
+		br <- reqScan{name: p[0], port: uint16(port), rep: ch}
+		select {
+		case rep := <-ch:
+			rm, _ := rep.(map[string]interface{})
+			ar.OK, _ = rm["stat"].(bool)
+			if !ar.OK {
+				ar.Mesg = fmt.Sprint(rm["mesg"])
+			}
+			audit(ar)
+			if hosts, ok := rm["data"].([]interface{}); ok { //只返回用户可连接的主机
+				list := []interface{}{}
+				for _, h := range hosts {
+					ip := net.ParseIP(fmt.Sprint(h))
+					if ip != nil && cf.permitConn(usr, p[0], ip, uint16(port)) {
+						list = append(list, h)
+					}
+				}
+				rm["data"] = list
+				if len(list) == 0 {
+					rm["stat"] = false
+					rm["mesg"] = fmt.Sprintf("no host opens port %d", port)
+					delete(rm, "data")
+				}
+			}
+			jsonReply(w, rep)
+		case <-time.After(chanLife):
+			ar.Mesg = "no reply"
+			audit(ar)
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "no reply",
+			})
+		}
 	}
 }
@@ -0,0 +1,64 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//apiSessions 管理员列出（GET /dk/site/<name>/sessions）或关闭
+//（DELETE /dk/site/<name>/sessions[/<id>]，不指定id则关闭所有连接）后端的连接
+func apiSessions(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		if !isAdmin(cf, usr) {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "admin only",
+			})
+			return
+		}
+		p := strings.Split(r.URL.Path[9:], "/")
+		if len(p) < 2 || len(p) > 3 || p[1] != "sessions" {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/sessions[/id] expected",
+			})
+			return
+		}
+		req := reqSess{name: p[0], rep: make(chan interface{}, 1)}
+		if r.Method == "DELETE" {
+			req.close = true
+			req.by = usr
+			if usr == "" {
+				req.by = "(local)"
+			}
+			if len(p) == 3 && p[2] != "" {
+				id, err := strconv.ParseUint(p[2], 16, 32)
+				if err != nil || id == 0 {
+					jsonReply(w, map[string]interface{}{
+						"stat": false,
+						"mesg": fmt.Sprintf("invalid session '%s'", p[2]),
+					})
+					return
+				}
+				req.id = uint32(id)
+			}
+		}
+		br <- req
+		select {
+		case rep := <-req.rep:
+			jsonReply(w, rep)
+		case <-time.After(chanLife):
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "no reply",
+			})
+		}
+	}
+}
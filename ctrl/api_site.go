@@ -5,19 +5,30 @@ import (
 	"time"
 )
 
-func apiSite(w http.ResponseWriter, r *http.Request) {
-	if !allowed(r) {
-		return
-	}
-	ch := make(chan interface{})
-	br <- reqList{"", ch}
-	select {
-	case rep := <-ch:
-		jsonReply(w, rep)
-	case <-time.After(chanLife):
-		jsonReply(w, map[string]interface{}{
-			"stat": false,
-			"mesg": "no reply",
-		})
+func apiSite(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		ch := make(chan interface{})
+		br <- reqList{"", ch}
+		select {
+		case rep := <-ch:
+			rm := rep.(map[string]interface{})
+			list := []map[string]interface{}{}
+			for _, s := range rm["data"].([]map[string]interface{}) {
+				if cf.permitSite(usr, s["name"].(string)) { //只列出用户可访问的后端
+					list = append(list, s)
+				}
+			}
+			rm["data"] = list
+			jsonReply(w, rm)
+		case <-time.After(chanLife):
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "no reply",
+			})
+		}
 	}
 }
@@ -0,0 +1,52 @@
+package ctrl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//apiLogout 撤销当前令牌并清除cookie
+func apiLogout(w http.ResponseWriter, r *http.Request) {
+	if usr, ok := TS.Get(r); ok {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		audit(auditRec{Event: "logout", OK: true, User: usr, From: host})
+	}
+	if tok := reqToken(r); tok != "" {
+		TS.Drop(tok)
+	}
+	setCookie(w, "t", "", -1)
+	jsonReply(w, map[string]interface{}{"stat": true})
+}
+
+//apiToken 列出（GET /dk/token）或撤销（DELETE /dk/token/<id>）当前用户的令牌
+func apiToken(w http.ResponseWriter, r *http.Request) {
+	usr, ok := identify(r)
+	if !ok {
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/dk/token"), "/")
+	if id == "" {
+		jsonReply(w, map[string]interface{}{
+			"stat": true,
+			"data": TS.List(usr, reqToken(r)),
+		})
+		return
+	}
+	if r.Method != "DELETE" {
+		jsonReply(w, map[string]interface{}{
+			"stat": false,
+			"mesg": "DELETE expected",
+		})
+		return
+	}
+	if !TS.Revoke(usr, id) {
+		jsonReply(w, map[string]interface{}{
+			"stat": false,
+			"mesg": fmt.Sprintf("token '%s' not found", id),
+		})
+		return
+	}
+	jsonReply(w, map[string]interface{}{"stat": true})
+}
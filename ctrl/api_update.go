@@ -0,0 +1,75 @@
+package ctrl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const maxUpdateSize = 64 * 1024 * 1024 //新程序的最大字节数
+
+//apiUpdate 查询（GET）后端自我更新的进度，或推送（POST，请求体为新程序）新程序
+func apiUpdate(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var name string
+		if len(r.URL.Path) > 11 {
+			name = r.URL.Path[11:]
+		}
+		if r.Method != "POST" && name == "" { //只列出用户可管理的后端
+			usr, ok := identify(r)
+			if !ok {
+				return
+			}
+			ss := updateProgress("").(map[string]updateStat)
+			for n := range ss {
+				if !cf.permitManage(usr, n) {
+					delete(ss, n)
+				}
+			}
+			jsonReply(w, map[string]interface{}{"stat": true, "data": ss})
+			return
+		}
+		if _, ok := siteUser(w, r, cf, name, true); !ok {
+			return
+		}
+		if r.Method != "POST" {
+			jsonReply(w, map[string]interface{}{
+				"stat": true,
+				"data": updateProgress(name),
+			})
+			return
+		}
+		key, ok := cf.Auths[name]
+		if !ok {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("backend '%s' not found", name),
+			})
+			return
+		}
+		bin, err := ioutil.ReadAll(http.MaxBytesReader(w, aliveBody{r}, maxUpdateSize))
+		if err != nil || len(bin) == 0 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid program: %v", err),
+			})
+			return
+		}
+		wait, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+		if wait < 30 || wait > 600 {
+			wait = 120
+		}
+		if !startUpdate(name, key, bin, wait) {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("backend '%s' is being updated", name),
+			})
+			return
+		}
+		jsonReply(w, map[string]interface{}{
+			"stat": true,
+			"mesg": fmt.Sprintf("updating %s (%d bytes)", name, len(bin)),
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package ctrl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxWolWait = 300 //等待目标端口开放的最长时间（秒）
+
+func apiWol(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(r.URL.Path[8:], "/")
+		if len(p) != 2 {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": "name/mac expected",
+			})
+			return
+		}
+		usr, ok := siteUser(w, r, cf, p[0], false)
+		if !ok {
+			return
+		}
+		if _, err := net.ParseMAC(p[1]); err != nil {
+			jsonReply(w, map[string]interface{}{
+				"stat": false,
+				"mesg": fmt.Sprintf("invalid MAC '%s'", p[1]),
+			})
+			return
+		}
+		q := r.URL.Query()
+		args := map[string]interface{}{"mac": p[1]}
+		if bc := q.Get("bcast"); bc != "" {
+			if ip := net.ParseIP(bc); ip == nil || ip.To4() == nil {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("broadcast '%s' is not valid IPv4", bc),
+				})
+				return
+			}
+			args["bcast"] = bc
+		}
+		life := chanLife
+		if port, _ := strconv.Atoi(q.Get("port")); port > 0 && port <= 65535 {
+			host := q.Get("host")
+			if net.ParseIP(host) == nil {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": fmt.Sprintf("host '%s' is not valid IP", host),
+				})
+				return
+			}
+			if !cf.permitConn(usr, p[0], net.ParseIP(host), uint16(port)) {
+				jsonReply(w, map[string]interface{}{
+					"stat": false,
+					"mesg": "access denied by policy",
+				})
+				return
+			}
+			wait, _ := strconv.Atoi(q.Get("wait"))
+			if wait <= 0 || wait > maxWolWait {
+				wait = 60
+			}
+			args["host"] = host
+			args["port"] = port
+			args["wait"] = wait
+			life += time.Duration(wait) * time.Second
+		}
+		keepAlive(r, life)
+		jsonReply(w, cmdReply(callBackend(p[0], 4, args, life)))
+	}
+}
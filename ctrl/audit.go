@@ -0,0 +1,223 @@
+package ctrl
+
+import (
+	"bufio"
+	"dk/base"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	//auditRec 网关审计记录。event为login、logout、auth、revoke、extend、scan、open或close，
+	//ok为false表示失败或被拒绝（原因见mesg）
+	auditRec struct {
+		Time     time.Time `json:"time"`
+		Event    string    `json:"event"`
+		OK       bool      `json:"ok"`
+		User     string    `json:"user,omitempty"`
+		From     string    `json:"from,omitempty"`   //用户的IP
+		Source   string    `json:"source,omitempty"` //授权的来源（IP或CIDR）
+		Site     string    `json:"site,omitempty"`
+		Dest     string    `json:"dest,omitempty"`
+		Port     int       `json:"port,omitempty"` //接入端口
+		Session  string    `json:"session,omitempty"`
+		Duration int64     `json:"duration,omitempty"` //连接时长（秒）
+		Mesg     string    `json:"mesg,omitempty"`
+	}
+	//sessInfo 网关维护的连接信息，用于审计
+	sessInfo struct {
+		user  string
+		from  string
+		dest  string
+		port  uint16 //接入端口
+		tid   string //令牌授权的标识
+		start time.Time
+		sent  int64 //客户端=>后端的字节数
+		recv  int64 //后端=>客户端的字节数
+		last  int64 //最后一次传输数据的时间（UNIX纳秒）
+	}
+)
+
+const auditQueue = 1024 //等待写入的审计记录数
+
+var auditLog struct {
+	file       string
+	split      int64 //文件超过该字节数则轮转
+	keep       int   //保留的已轮转文件数（file.1、file.2……）
+	ch         chan []byte
+	sync.Mutex //轮转与查询时打开文件互斥
+}
+
+func initAudit(cf Config) {
+	auditLog.file = cf.Audit
+	auditLog.split = cf.AuditSize
+	auditLog.keep = cf.AuditKeep
+	if auditLog.file != "" {
+		auditLog.ch = make(chan []byte, auditQueue)
+		go writeAudit()
+	}
+}
+
+//audit 将一条记录交给writeAudit追加到审计记录文件（JSON lines格式），
+//除非队列已满，否则不会阻塞调用者
+func audit(ar auditRec) {
+	if auditLog.ch == nil {
+		return
+	}
+	ar.Time = time.Now()
+	buf, _ := json.Marshal(ar)
+	auditLog.ch <- append(buf, '\n')
+}
+
+//writeAudit 保持审计记录文件打开并依次写入队列中的记录，文件超过split字节时轮转，
+//失败只记录LOG
+func writeAudit() {
+	var (
+		f    *os.File
+		size int64
+	)
+	for buf := range auditLog.ch {
+		if f == nil {
+			var err error
+			f, err = os.OpenFile(auditLog.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+			if err != nil {
+				base.Log("audit: %v", err)
+				continue
+			}
+			size = 0
+			if st, err := f.Stat(); err == nil {
+				size = st.Size()
+			}
+		}
+		n, err := f.Write(buf)
+		size += int64(n)
+		if err != nil {
+			base.Log("audit: %v", err)
+		}
+		if err != nil || size >= auditLog.split {
+			f.Close()
+			f = nil
+		}
+		if size >= auditLog.split {
+			rotateAudit()
+		}
+	}
+}
+
+//auditFile 返回第i个审计记录文件（0为当前文件，其余为已轮转的文件，越大越旧）
+func auditFile(i int) string {
+	if i == 0 {
+		return auditLog.file
+	}
+	return fmt.Sprintf("%s.%d", auditLog.file, i)
+}
+
+//rotateAudit 将审计记录文件依次改名为file.1、file.2……，删除超过keep的文件
+func rotateAudit() {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	os.Remove(auditFile(auditLog.keep))
+	for i := auditLog.keep; i > 0; i-- {
+		if err := os.Rename(auditFile(i-1), auditFile(i)); err != nil && !os.IsNotExist(err) {
+			base.Log("audit: %v", err)
+		}
+	}
+}
+
+//queryAudit 从最新的审计记录文件开始向前查找，返回符合条件的最近limit条记录（按时间顺序）。
+//每个文件的大小有限，找到足够的记录或已早于since即停止，不必扫描全部文件
+func queryAudit(match func(auditRec) bool, since time.Time, limit int) ([]auditRec, error) {
+	recs := []auditRec{}
+	for i := 0; i <= auditLog.keep && len(recs) < limit; i++ {
+		auditLog.Lock()
+		f, err := os.Open(auditFile(i))
+		auditLog.Unlock()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var part []auditRec
+		older := false
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var ar auditRec
+			if json.Unmarshal(s.Bytes(), &ar) != nil {
+				continue
+			}
+			if ar.Time.Before(since) {
+				older = true
+				continue
+			}
+			if !match(ar) {
+				continue
+			}
+			part = append(part, ar)
+			if len(part) > limit-len(recs) {
+				part = part[1:]
+			}
+		}
+		f.Close()
+		recs = append(part, recs...)
+		if older {
+			break
+		}
+	}
+	return recs, nil
+}
+
+//apiAudit 查询审计记录：/dk/audit?user=<name>&site=<name>&since=<time>&until=<time>&limit=<n>，
+//时间为RFC3339格式，最多返回最近的limit条（默认100，最多10000）。非管理员只能查询自己的记录
+func apiAudit(cf Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, ok := identify(r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		user := q.Get("user")
+		if !isAdmin(cf, usr) {
+			user = usr
+		}
+		site := q.Get("site")
+		var since, until time.Time
+		for _, t := range []struct {
+			name string
+			val  *time.Time
+		}{{"since", &since}, {"until", &until}} {
+			if v := q.Get(t.name); v != "" {
+				tv, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					jsonReply(w, map[string]interface{}{
+						"stat": false,
+						"mesg": t.name + ": RFC3339 time expected",
+					})
+					return
+				}
+				*t.val = tv
+			}
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if limit <= 0 {
+			limit = 100
+		}
+		if limit > 10000 {
+			limit = 10000
+		}
+		recs, err := queryAudit(func(ar auditRec) bool {
+			return (user == "" || ar.User == user) && (site == "" || ar.Site == site) &&
+				(until.IsZero() || !ar.Time.After(until))
+		}, since, limit)
+		if err != nil {
+			jsonReply(w, map[string]interface{}{"stat": false, "mesg": err.Error()})
+			return
+		}
+		jsonReply(w, map[string]interface{}{"stat": true, "data": recs})
+	}
+}
@@ -5,13 +5,12 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-
-	"github.com/pquerna/otp/totp"
 )
 
 var (
-	allowed func(r *http.Request) bool
-	pid     string
+	allowed  func(r *http.Request) bool
+	identify func(r *http.Request) (string, bool) //返回请求者的用户名（以PID访问的本机用户为空）
+	pid      string
 )
 
 func init() {
@@ -24,25 +23,62 @@ func authChk(r *http.Request, cf Config) (string, bool) {
 		usr = getCookie(r, "u")
 	}
 	otp := r.URL.Query().Get("p")
-	key := cf.Users[usr]
-	if totp.Validate(otp, key) {
-		return usr, true
+	if otp == "" {
+		return "", false
 	}
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
 	ip := net.ParseIP(host)
-	if ip == nil || !ip.IsLoopback() {
+	if ip != nil && ip.IsLoopback() && pid == otp {
+		return "", true
+	}
+	name := usr
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	key, known := cf.Users[usr]
+	if !known {
+		usr = "" //不为不存在的用户记录失败
+	}
+	if LO.Locked(host, usr) {
+		audit(auditRec{Event: "login", User: name, From: host, Mesg: "locked out"})
 		return "", false
 	}
-	return "", pid == otp
+	if known && OS.Validate(usr, otp, key) {
+		LO.Pass(host, usr)
+		audit(auditRec{Event: "login", OK: true, User: usr, From: host})
+		return usr, true
+	}
+	LO.Fail(host, usr)
+	audit(auditRec{Event: "login", User: name, From: host, Mesg: "invalid OTP"})
+	return "", false
 }
 
-func setWatchdog(cf Config) {
-	initTokenStore(cf.AuthTime)
-	allowed = func(r *http.Request) bool {
-		if TS.Get(r) {
+//isAdmin 检查用户是否为管理员（以PID访问的本机用户总是管理员）
+func isAdmin(cf Config, usr string) bool {
+	if usr == "" {
+		return true
+	}
+	for _, a := range cf.Admins {
+		if a == usr {
 			return true
 		}
-		_, ok := authChk(r, cf)
+	}
+	return false
+}
+
+func setWatchdog(cf Config) {
+	initTokenStore(cf.AuthTime, cf.TokenFile)
+	initLockouts(cf)
+	initOTPState(cf.OTPState)
+	initAudit(cf)
+	identify = func(r *http.Request) (string, bool) {
+		if usr, ok := TS.Get(r); ok {
+			return usr, true
+		}
+		return authChk(r, cf)
+	}
+	allowed = func(r *http.Request) bool {
+		_, ok := identify(r)
 		return ok
 	}
 }
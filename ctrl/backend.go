@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	queueCap = 1024 //包处理队列长度
+	queueCap                = 1024 //包处理队列长度
+	chunkSES base.ChunkType = 5    //查询或关闭连接（内部使用）
 )
 
 type (
@@ -21,9 +24,13 @@ type (
 		arg interface{}
 	}
 	backend struct {
+		name string
 		serv net.Conn
 		comm chan chunk
 		clis map[uint32]*base.Conn
+		sess map[uint32]*sessInfo   //连接的审计信息
+		info map[string]interface{} //后端上报的元数据
+		seen time.Time              //收到元数据的时间
 	}
 	backends map[string]*backend
 	reqServ  struct { //后端注册
@@ -34,12 +41,26 @@ type (
 		session uint32
 		backend string
 		dest    []byte //格式：大端序uint16端口号+net.IP格式的目标IP
+		user    string
+		tid     string //令牌授权的标识（经令牌端口接入时）
 		conn    net.Conn
 	}
 	reqList struct { //列出指定后端及其状态、活跃连接数（name为空则为所有后端）
 		name string
 		rep  chan interface{}
 	}
+	reqSess struct { //列出或关闭后端的连接
+		name  string
+		id    uint32 //要关闭的连接，为0表示关闭所有连接
+		close bool
+		by    string //执行关闭的管理员
+		serv  uint16 //只关闭经该接入端口、来自src，且属于user、目标为dest的连接（撤销授权时）
+		src   *net.IPNet
+		user  string
+		dest  string
+		tid   string //只关闭经该令牌授权建立的连接（撤销令牌授权时）
+		rep   chan interface{}
+	}
 	reqScan struct { //扫描后端开放某端口的主机
 		name string
 		port uint16
@@ -49,9 +70,118 @@ type (
 		sid uint32
 		msg map[string]interface{}
 	}
+	repInfo struct { //后端上报的元数据
+		back *backend
+		info map[string]interface{}
+	}
 )
 
-func (b *backend) Remove(session uint32) {
+//supports 检查后端在元数据中是否声明支持某项功能
+func (b *backend) supports(feature string) bool {
+	fs, _ := b.info["features"].([]interface{})
+	for _, f := range fs {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+//withClient 将目标扩展为携带客户端地址的格式：端口+16字节目标IP+客户端端口+16字节客户端IP
+func withClient(dest []byte, client net.Addr) []byte {
+	ta, ok := client.(*net.TCPAddr)
+	if !ok || len(dest) < 2 {
+		return dest
+	}
+	buf := append([]byte{}, dest[:2]...)
+	buf = append(buf, net.IP(dest[2:]).To16()...)
+	buf = append(buf, byte(ta.Port>>8), byte(ta.Port))
+	return append(buf, ta.IP.To16()...)
+}
+
+//withUser 在扩展格式的目标之后附加用户名（1字节长度+用户名）
+func withUser(dest []byte, user string) []byte {
+	if len(dest) != 2*(2+net.IPv6len) {
+		return dest
+	}
+	if len(user) > 255 {
+		user = user[:255]
+	}
+	return append(append(dest, byte(len(user))), user...)
+}
+
+//addrOf 将目标（端口+IP）表示为"IP:端口"
+func addrOf(dest []byte) string {
+	if len(dest) < 2+net.IPv4len {
+		return ""
+	}
+	return net.JoinHostPort(net.IP(dest[2:]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(dest))))
+}
+
+//listSessions 列出后端的所有连接（仅由包处理线程调用）
+func (b *backend) listSessions() map[string]interface{} {
+	list := []map[string]interface{}{}
+	for id, c := range b.clis {
+		s := map[string]interface{}{"session": fmt.Sprintf("%08x", id)}
+		if ra := c.Remote(); ra != nil {
+			s["client"] = ra.String()
+		}
+		if si := b.sess[id]; si != nil {
+			s["user"] = si.user
+			s["dest"] = si.dest
+			s["start"] = si.start.Format(time.RFC3339)
+			s["idle"] = int(time.Since(time.Unix(0, atomic.LoadInt64(&si.last))).Seconds())
+			s["sent"] = atomic.LoadInt64(&si.sent)
+			s["recv"] = atomic.LoadInt64(&si.recv)
+			if si.tid != "" {
+				s["token"] = si.tid
+			}
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return fmt.Sprint(list[i]["start"]) < fmt.Sprint(list[j]["start"])
+	})
+	return map[string]interface{}{"stat": true, "data": list}
+}
+
+//closeSessions 关闭指定连接（id为0表示所有连接），通知后端及客户端（仅由包处理线程调用）
+func (b *backend) closeSessions(req reqSess) map[string]interface{} {
+	id := req.id
+	if id != 0 && b.clis[id] == nil {
+		return map[string]interface{}{
+			"stat": false,
+			"mesg": fmt.Sprintf("session %08x not found", id),
+		}
+	}
+	reason := "closed by admin " + req.by
+	if req.serv != 0 || req.tid != "" {
+		reason = "auth revoked by " + req.by
+	}
+	closed := []string{}
+	for s := range b.clis {
+		if id != 0 && s != id {
+			continue
+		}
+		if si := b.sess[s]; req.serv != 0 && (si == nil || si.port != req.serv || !req.src.Contains(net.ParseIP(si.from)) ||
+			si.user != req.user || si.dest != req.dest) {
+			continue
+		}
+		if si := b.sess[s]; req.tid != "" && (si == nil || si.tid != req.tid) {
+			continue
+		}
+		base.Close(b.serv, s)
+		b.Remove(s, reason)
+		closed = append(closed, fmt.Sprintf("%08x", s))
+	}
+	if len(closed) > 0 {
+		base.Log("[%s] %d session(s) %s", b.name, len(closed), reason)
+	}
+	return map[string]interface{}{"stat": true, "data": closed}
+}
+
+func (b *backend) Remove(session uint32, reason string) {
+	clearConsent(session)
 	s := b.clis[session]
 	if s == nil {
 		return
@@ -59,22 +189,46 @@ func (b *backend) Remove(session uint32) {
 	s.Close()
 	base.Dbg("removed session %x", session)
 	delete(b.clis, session)
+	b.closed(session, reason)
+}
+
+//closed 记录连接关闭的审计信息
+func (b *backend) closed(session uint32, reason string) {
+	si := b.sess[session]
+	if si == nil {
+		return
+	}
+	delete(b.sess, session)
+	audit(auditRec{
+		Event:    "close",
+		OK:       true,
+		User:     si.user,
+		From:     si.from,
+		Site:     b.name,
+		Dest:     si.dest,
+		Session:  fmt.Sprintf("%08x", session),
+		Duration: int64(time.Since(si.start).Seconds()),
+		Mesg:     reason,
+	})
 }
 
 func (b *backend) Free() {
 	if b.serv != nil {
 		b.serv.Close()
 	}
-	for _, c := range b.clis {
+	for s, c := range b.clis {
 		c.Close()
+		b.closed(s, "backend disconnected")
 	}
 }
 
 func NewBackend(name string, conn net.Conn, cf Config) *backend {
 	b := &backend{
+		name: name,
 		serv: conn,
 		comm: make(chan chunk, queueCap),
 		clis: make(map[uint32]*base.Conn),
+		sess: make(map[uint32]*sessInfo),
 	}
 	if cf.KeepAlive > 0 { //定时PING后端，保持连接不被NAT防火墙关闭
 		go func() {
@@ -99,7 +253,14 @@ func NewBackend(name string, conn net.Conn, cf Config) *backend {
 			}
 			switch c.cls {
 			case base.ChunkCLS:
-				b.Remove(session)
+				reason := "closed by backend"
+				if r, ok := c.arg.(string); ok { //由网关一侧关闭
+					reason = r
+				} else if len(data) > 0 { //后端拒绝或中止连接，并说明了原因
+					base.Log("[%s] session %x closed by backend: %s", name, session, string(data))
+					reason += ": " + string(data)
+				}
+				b.Remove(session, reason)
 			case base.ChunkDAT:
 				s := b.clis[session]
 				if s == nil {
@@ -108,7 +269,19 @@ func NewBackend(name string, conn net.Conn, cf Config) *backend {
 				}
 				if err := s.Send(data); err != nil {
 					base.Log("dispatch[%x]: %v", session, err)
-					b.Remove(session)
+					b.Remove(session, "client error")
+					break
+				}
+				if si := b.sess[session]; si != nil {
+					atomic.AddInt64(&si.recv, int64(len(data)))
+					atomic.StoreInt64(&si.last, time.Now().UnixNano())
+				}
+			case chunkSES:
+				req := c.arg.(reqSess)
+				if req.close {
+					req.rep <- b.closeSessions(req)
+				} else {
+					req.rep <- b.listSessions()
 				}
 			case base.ChunkCMD:
 				switch data[0] {
@@ -118,6 +291,33 @@ func NewBackend(name string, conn net.Conn, cf Config) *backend {
 					var rep map[string]interface{}
 					json.Unmarshal(data[1:], &rep)
 					br <- repScan{session, rep}
+				case 2:
+					var info map[string]interface{}
+					if err := json.Unmarshal(data[1:], &info); err != nil {
+						base.Log("[%s] invalid site info: %v", name, err)
+						break
+					}
+					br <- repInfo{b, info}
+				case 14:
+					var cs struct {
+						Session uint32 `json:"session"`
+						Stat    string `json:"stat"`
+						Dest    string `json:"dest"`
+					}
+					if err := json.Unmarshal(data[1:], &cs); err != nil {
+						base.Log("[%s] invalid consent status: %v", name, err)
+						break
+					}
+					base.Log("[%s] session %x => %s: %s", name, cs.Session, cs.Dest, cs.Stat)
+					if s := b.clis[cs.Session]; s != nil && s.Remote() != nil {
+						setConsent(name, cs.Session, s.Remote().IP, cs.Stat, cs.Dest)
+					}
+				default:
+					if len(data) < 2 {
+						base.Log("[%s] invalid reply: %x", name, data)
+						break
+					}
+					br <- repCmd{session, data[1] == 1, data[2:]}
 				}
 			case base.ChunkCON:
 				if session == 0 { //清理空闲连接
@@ -127,18 +327,37 @@ func NewBackend(name string, conn net.Conn, cf Config) *backend {
 							base.Dbg("[%s] closing idle session %x", name, s)
 							c.Close()
 							delete(b.clis, s)
+							b.closed(s, "idle")
 						}
 					}
 					break
 				}
 				//创建新连接
-				conn, ok := c.arg.(net.Conn)
+				req, ok := c.arg.(reqConn)
 				if !ok {
 					base.Log("[%s] invalid arg type: %T", name, c.arg)
 					break
 				}
-				b.Remove(session)
+				conn := req.conn
+				b.Remove(session, "replaced")
 				b.clis[session] = base.NewConn(conn)
+				from, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+				dest := addrOf(req.dest)
+				now := time.Now()
+				port := conn.LocalAddr().(*net.TCPAddr).Port
+				si := &sessInfo{user: req.user, from: from, dest: dest, port: uint16(port),
+					tid: req.tid, start: now, last: now.UnixNano()}
+				b.sess[session] = si
+				audit(auditRec{
+					Event:   "open",
+					OK:      true,
+					User:    req.user,
+					From:    from,
+					Site:    name,
+					Dest:    dest,
+					Port:    port,
+					Session: fmt.Sprintf("%08x", session),
+				})
 				base.Open(b.serv, session, data)
 				go func(c net.Conn) {
 					defer func() {
@@ -147,14 +366,16 @@ func NewBackend(name string, conn net.Conn, cf Config) *backend {
 							base.Close(b.serv, session)
 							buf := make([]byte, 4)
 							binary.BigEndian.PutUint32(buf, session)
-							b.comm <- chunk{base.ChunkCLS, buf, nil}
+							b.comm <- chunk{base.ChunkCLS, buf, "closed by client"}
 						}
 					}()
-					data := make([]byte, base.MTU-2)
+					data := make([]byte, base.MaxData)
 					for {
 						n, err := c.Read(data)
 						assert(err)
 						assert(base.Send(b.serv, session, data[:n]))
+						atomic.AddInt64(&si.sent, int64(n))
+						atomic.StoreInt64(&si.last, time.Now().UnixNano())
 					}
 				}(conn)
 			}
@@ -223,8 +444,15 @@ func startBackendRegistrar(cf Config) {
 				}
 				buf := make([]byte, 4)
 				binary.BigEndian.PutUint32(buf, req.session)
-				buf = append(buf, req.dest...)
-				b.comm <- chunk{base.ChunkCON, buf, req.conn}
+				switch {
+				case b.supports("user"): //旧版本后端不能识别扩展格式
+					buf = append(buf, withUser(withClient(req.dest, req.conn.RemoteAddr()), req.user)...)
+				case b.supports("proxy"):
+					buf = append(buf, withClient(req.dest, req.conn.RemoteAddr())...)
+				default:
+					buf = append(buf, req.dest...)
+				}
+				b.comm <- chunk{base.ChunkCON, buf, req}
 			case reqList:
 				req := cmd.(reqList)
 				list := []map[string]interface{}{}
@@ -238,6 +466,16 @@ func startBackendRegistrar(cf Config) {
 						s = map[string]interface{}{"name": n, "conn": -1}
 					} else {
 						s = map[string]interface{}{"name": n, "conn": len(b.clis)}
+						if b.info != nil {
+							info := make(map[string]interface{})
+							for k, v := range b.info {
+								info[k] = v
+							}
+							if up, ok := info["uptime"].(float64); ok {
+								info["uptime"] = int(up + time.Since(b.seen).Seconds())
+							}
+							s["info"] = info
+						}
 					}
 					list = append(list, s)
 				}
@@ -247,6 +485,17 @@ func startBackendRegistrar(cf Config) {
 					return ni < nj
 				})
 				req.rep <- map[string]interface{}{"stat": true, "data": list}
+			case reqSess:
+				req := cmd.(reqSess)
+				b := bs[req.name]
+				if b == nil {
+					req.rep <- map[string]interface{}{
+						"stat": false,
+						"mesg": fmt.Sprintf("backend '%s' not found", req.name),
+					}
+					break
+				}
+				b.comm <- chunk{chunkSES, nil, req}
 			case reqScan:
 				req := cmd.(reqScan)
 				b := bs[req.name]
@@ -260,7 +509,7 @@ func startBackendRegistrar(cf Config) {
 				buf := make([]byte, 3)
 				buf[0] = 1
 				binary.BigEndian.PutUint16(buf[1:], req.port)
-				cid := setChan(req.rep)
+				cid := setChan(req.rep, chanLife)
 				base.Reply(b.serv, cid, buf)
 			case repScan:
 				rep := cmd.(repScan)
@@ -268,6 +517,35 @@ func startBackendRegistrar(cf Config) {
 				if ch != nil {
 					ch <- rep.msg
 				}
+			case reqCmd:
+				req := cmd.(reqCmd)
+				b := bs[req.name]
+				if b == nil {
+					req.rep <- fmt.Errorf("backend '%s' not found", req.name)
+					break
+				}
+				b.sendCmd(req)
+			case reqLink:
+				req := cmd.(reqLink)
+				b := bs[req.name]
+				if b == nil {
+					req.rep <- fmt.Errorf("backend '%s' not found", req.name)
+					break
+				}
+				req.rep <- b.serv
+			case repCmd:
+				dispatchReply(cmd.(repCmd))
+			case repInfo:
+				rep := cmd.(repInfo)
+				for n, b := range bs {
+					if b == rep.back {
+						b.info = rep.info
+						b.seen = time.Now()
+						base.Dbg("[%s] site info: %v", n, rep.info)
+						updateOnline(n, rep.info)
+						break
+					}
+				}
 			}
 		}
 	}()
@@ -0,0 +1,137 @@
+package ctrl
+
+import (
+	"bytes"
+	"dk/base"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const dataChunk = 8000 //直接发送给后端的数据块大小（需小于MTU）
+
+type (
+	reqCmd struct { //向后端发送命令（命令代码>=3，参数与回复均为JSON）
+		name string
+		code byte
+		args []byte
+		life time.Duration
+		rep  chan interface{}
+	}
+	repCmd struct { //后端对命令的回复（分片）
+		sid  uint32
+		last bool
+		data []byte
+	}
+	reqLink struct { //获取后端的主控连接
+		name string
+		rep  chan interface{}
+	}
+)
+
+//sendCmd 由后端注册器调用，将命令发送给后端
+func (b *backend) sendCmd(req reqCmd) {
+	cid := setChan(req.rep, req.life)
+	if err := base.Reply(b.serv, cid, append([]byte{req.code}, req.args...)); err != nil {
+		getChan(cid)
+		req.rep <- err
+	}
+}
+
+//dispatchReply 由后端注册器调用，将回复分片转发给等待者。为免阻塞注册器，
+//通道已满时不再等待，而是注销并关闭该通道，等待者读完已缓存的分片后即以
+//"reply truncated"中止调用，不会收到残缺的回复
+func dispatchReply(rep repCmd) {
+	var ch chan interface{}
+	if rep.last {
+		ch = getChan(rep.sid)
+	} else {
+		ch = peekChan(rep.sid)
+	}
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- rep:
+	default:
+		base.Log("dispatchReply[%x]: reply truncated", rep.sid)
+		if !rep.last {
+			getChan(rep.sid)
+		}
+		close(ch)
+	}
+}
+
+//streamBackend 向名为name的后端发送命令，每收到一个回复分片即调用part，
+//直到收到最后一片或超时（life为最长等待时间）
+func streamBackend(name string, code byte, args interface{}, life time.Duration, part func([]byte) error) error {
+	buf, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	ch := make(chan interface{}, 256)
+	br <- reqCmd{name: name, code: code, args: buf, life: life, rep: ch}
+	deadline := time.After(life)
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return errors.New("reply truncated")
+			}
+			switch r := r.(type) {
+			case error:
+				return r
+			case repCmd:
+				if err := part(r.data); err != nil {
+					return err
+				}
+				if r.last {
+					return nil
+				}
+			}
+		case <-deadline:
+			return errors.New("no reply")
+		}
+	}
+}
+
+//callBackend 向名为name的后端发送命令，并等待其回复（life为最长等待时间）
+func callBackend(name string, code byte, args interface{}, life time.Duration) (map[string]interface{}, error) {
+	var data bytes.Buffer
+	err := streamBackend(name, code, args, life, func(p []byte) error {
+		_, err := data.Write(p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rep map[string]interface{}
+	if err := json.Unmarshal(data.Bytes(), &rep); err != nil {
+		return nil, fmt.Errorf("invalid reply: %v", err)
+	}
+	return rep, nil
+}
+
+//backendConn 获取名为name的后端的主控连接，用于直接向后端发送大量数据，
+//以免阻塞后端注册器
+func backendConn(name string) (net.Conn, error) {
+	ch := make(chan interface{}, 1)
+	br <- reqLink{name, ch}
+	switch r := (<-ch).(type) {
+	case net.Conn:
+		return r, nil
+	case error:
+		return nil, r
+	}
+	return nil, errors.New("invalid reply")
+}
+
+//cmdReply 将callBackend的结果返回给API调用者
+func cmdReply(rep map[string]interface{}, err error) map[string]interface{} {
+	if err != nil {
+		return map[string]interface{}{"stat": false, "mesg": err.Error()}
+	}
+	return rep
+}
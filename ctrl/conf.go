@@ -2,17 +2,31 @@ package ctrl
 
 type (
 	Config struct {
-		MgmtPort  int               `yaml:"mgmt_port"`
-		ServPort  int               `yaml:"serv_port"`
-		MaxServes int               `yaml:"max_serves"`
-		Handshake int               `yaml:"handshake"`
-		KeepAlive int               `yaml:"keep_alive"`
-		IdleClose int               `yaml:"idle_close"`
-		AuthTime  int               `yaml:"auth_time"`
-		OTPIssuer string            `yaml:"otp_issuer"`
-		WebRoot   string            `yaml:"web_root"`
-		Users     map[string]string `yaml:"users"`
-		Auths     map[string]string `yaml:"auths"`
-		Version   string            `yaml:"-"`
+		MgmtPort  int                 `yaml:"mgmt_port"`
+		TLSCert   string              `yaml:"tls_cert"`
+		TLSKey    string              `yaml:"tls_key"`
+		HTTPPort  int                 `yaml:"http_port"`
+		ServPort  int                 `yaml:"serv_port"`
+		TokenPort int                 `yaml:"token_port"`
+		MaxServes int                 `yaml:"max_serves"`
+		Handshake int                 `yaml:"handshake"`
+		KeepAlive int                 `yaml:"keep_alive"`
+		IdleClose int                 `yaml:"idle_close"`
+		AuthTime  int                 `yaml:"auth_time"`
+		TokenFile string              `yaml:"token_file"`
+		Audit     string              `yaml:"audit"`
+		AuditSize int64               `yaml:"audit_size"` //审计记录文件的最大字节数，超过则轮转
+		AuditKeep int                 `yaml:"audit_keep"` //保留的已轮转审计记录文件数
+		OTPIssuer string              `yaml:"otp_issuer"`
+		OTPState  string              `yaml:"otp_state"`
+		WebRoot   string              `yaml:"web_root"`
+		LockAfter int                 `yaml:"lock_after"`
+		LockTime  int                 `yaml:"lock_time"`
+		Users     map[string]string   `yaml:"users"`
+		Admins    []string            `yaml:"admins"`
+		Groups    map[string][]string `yaml:"groups"`
+		Policies  map[string]*Policy  `yaml:"policies"`
+		Auths     map[string]string   `yaml:"auths"`
+		Version   string              `yaml:"-"`
 	}
 )
@@ -0,0 +1,65 @@
+package ctrl
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const consentKeep = time.Minute //被拒绝的连接请求的状态保留时间，以便用户查看
+
+type consentStat struct { //后端本地用户对连接请求的确认状态
+	site string
+	from net.IP //用户端IP
+	host net.IP
+	port uint16
+	stat string //waiting、approved或denied
+	time time.Time
+}
+
+var consents = struct {
+	m map[uint32]*consentStat
+	sync.Mutex
+}{m: make(map[uint32]*consentStat)}
+
+func setConsent(site string, session uint32, from net.IP, stat, dest string) {
+	h, p, _ := net.SplitHostPort(dest)
+	port, _ := strconv.Atoi(p)
+	consents.Lock()
+	defer consents.Unlock()
+	consents.m[session] = &consentStat{
+		site: site,
+		from: from,
+		host: net.ParseIP(h),
+		port: uint16(port),
+		stat: stat,
+		time: time.Now(),
+	}
+}
+
+//clearConsent 连接关闭时调用，被拒绝的请求保留一段时间
+func clearConsent(session uint32) {
+	consents.Lock()
+	defer consents.Unlock()
+	for s, c := range consents.m {
+		if s == session && c.stat != "denied" || time.Since(c.time) > consentKeep {
+			delete(consents.m, s)
+		}
+	}
+}
+
+//consentOf 查询来自from的用户连接site后端的host:port的确认状态，
+//同时有多个连接时，优先返回waiting
+func consentOf(from net.IP, site string, host net.IP, port uint16) (stat string) {
+	consents.Lock()
+	defer consents.Unlock()
+	for _, c := range consents.m {
+		if c.site == site && c.from.Equal(from) && c.host.Equal(host) && c.port == port {
+			if stat == "" || c.stat == "waiting" {
+				stat = c.stat
+			}
+		}
+	}
+	return
+}
@@ -11,6 +11,9 @@ func Start(cf Config) {
 	initAdapterManager(cf)
 	startAdminInterface(cf)
 	startBackendRegistrar(cf)
+	if cf.TokenPort > 0 {
+		startTokenPort(cf)
+	}
 	handshake := time.Duration(cf.Handshake) * time.Second
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cf.ServPort))
 	assert(err)
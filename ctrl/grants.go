@@ -0,0 +1,130 @@
+package ctrl
+
+import (
+	"dk/base"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	minSrcBits4 = 16 //授权来源网段的最小前缀长度（IPv4）
+	minSrcBits6 = 48 //授权来源网段的最小前缀长度（IPv6）
+)
+
+const (
+	authAll    = iota + 1 //列出所有接入端口的授权（管理员）
+	authRevoke            //撤销授权并关闭其连接
+	authExtend            //授权延期
+)
+
+//sourceNet 解析授权的来源（IP或CIDR），网段不能过大
+func sourceNet(s string) (*net.IPNet, error) {
+	n, err := parseNet(s)
+	if err != nil {
+		return nil, err
+	}
+	bits, min := prefixLen(n), minSrcBits6
+	if len(n.IP) == net.IPv4len {
+		min = minSrcBits4
+	}
+	if bits < min {
+		return nil, fmt.Errorf("CIDR '%s' too large (at least /%d)", s, min)
+	}
+	return n, nil
+}
+
+//hostNet 返回只包含ip的网段
+func hostNet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func prefixLen(n *net.IPNet) int {
+	bits, _ := n.Mask.Size()
+	return bits
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+//manage 列出、撤销或延期授权（仅由接口管理线程调用）
+func (das *dkAdapters) manage(ar authReq, cf Config) map[string]interface{} {
+	if ar.op == authAll {
+		list := []map[string]interface{}{}
+		for p, da := range das.as {
+			for _, a := range da.listAuths() {
+				if time.Now().After(a.time) {
+					continue
+				}
+				list = append(list, map[string]interface{}{
+					"port":  p,
+					"from":  a.src.String(),
+					"user":  a.user,
+					"site":  a.name,
+					"addr":  fmt.Sprintf("%s:%d", a.host, a.port),
+					"until": a.time.Format(time.RFC3339),
+				})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i]["until"].(string) < list[j]["until"].(string)
+		})
+		return map[string]interface{}{"stat": true, "data": list}
+	}
+	da := das.as[ar.serv]
+	var a *authReq
+	if da != nil {
+		a = da.findAuth(ar.src)
+	}
+	if a == nil || time.Now().After(a.time) {
+		return map[string]interface{}{
+			"stat": false,
+			"mesg": fmt.Sprintf("no auth for %s on port %d", ar.src, ar.serv),
+		}
+	}
+	if !ar.admin && a.user != ar.user {
+		return map[string]interface{}{"stat": false, "mesg": "not your auth"}
+	}
+	by := ar.user
+	if by == "" {
+		by = "(local)"
+	}
+	if ar.op == authRevoke {
+		da.delAuth(a)
+		base.Log("[adapter#%d] auth for %s revoked by %s", ar.serv, a.src, by)
+		audit(auditRec{Event: "revoke", OK: true, User: a.user, From: a.from.String(), Source: a.src.String(), Site: a.name,
+			Dest: fmt.Sprintf("%s:%d", a.host, a.port), Port: int(ar.serv), Mesg: "revoked by " + by})
+		//关闭通过该授权建立的连接（不等待结果）
+		br <- reqSess{name: a.name, close: true, by: by, serv: ar.serv, src: a.src, user: a.user,
+			dest: net.JoinHostPort(a.host.String(), strconv.Itoa(int(a.port))), rep: make(chan interface{}, 1)}
+		return map[string]interface{}{"stat": true}
+	}
+	secs := ar.secs
+	if secs <= 0 || secs > cf.AuthTime {
+		secs = cf.AuthTime
+	}
+	until := time.Now().Add(time.Duration(secs) * time.Second)
+	if !until.After(a.time) { //延期不会缩短授权
+		return map[string]interface{}{
+			"stat": true,
+			"data": map[string]interface{}{"until": a.time.Format(time.RFC3339)},
+		}
+	}
+	na := *a //不修改原授权，以免与读取者冲突
+	na.time = until
+	da.setAuth(&na)
+	base.Log("[adapter#%d] auth for %s extended to %s by %s", ar.serv, a.src,
+		na.time.Format(time.RFC3339), by)
+	audit(auditRec{Event: "extend", OK: true, User: a.user, From: a.from.String(), Source: a.src.String(), Site: a.name,
+		Dest: fmt.Sprintf("%s:%d", a.host, a.port), Port: int(ar.serv), Mesg: "extended by " + by})
+	return map[string]interface{}{
+		"stat": true,
+		"data": map[string]interface{}{"until": na.time.Format(time.RFC3339)},
+	}
+}
@@ -0,0 +1,73 @@
+package ctrl
+
+import (
+	"dk/base"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSourceNet(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string //为空表示应当出错
+	}{
+		{"1.2.3.4", "1.2.3.4/32"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"10.1.2.3/16", "10.1.0.0/16"},
+		{"10.0.0.0/15", ""},
+		{"0.0.0.0/0", ""},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::/48", "2001:db8::/48"},
+		{"2001:db8::/47", ""},
+		{"10.0.0", ""},
+		{"10.0.0.0/40", ""},
+	}
+	for _, c := range cases {
+		n, err := sourceNet(c.src)
+		switch {
+		case c.want == "" && err == nil:
+			t.Errorf("sourceNet(%q) = %s, want error", c.src, n)
+		case c.want != "" && err != nil:
+			t.Errorf("sourceNet(%q): %v", c.src, err)
+		case c.want != "" && n.String() != c.want:
+			t.Errorf("sourceNet(%q) = %s, want %s", c.src, n, c.want)
+		}
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	serv, gw := net.Pipe()
+	defer gw.Close()
+	go io.Copy(ioutil.Discard, serv) //丢弃发往后端的关闭通知
+	b := &backend{name: "site1", serv: gw, clis: make(map[uint32]*base.Conn), sess: make(map[uint32]*sessInfo)}
+	sessions := []sessInfo{
+		{user: "bob", from: "10.8.1.2", dest: "192.168.1.1:22", port: 9001},
+		{user: "bob", from: "10.8.1.3", dest: "192.168.1.1:22", port: 9001},
+		{user: "bob", from: "10.8.1.2", dest: "192.168.1.2:22", port: 9001}, //同一来源的其他授权
+		{user: "carol", from: "10.8.1.2", dest: "192.168.1.1:22", port: 9001},
+		{user: "bob", from: "10.9.1.2", dest: "192.168.1.1:22", port: 9001},
+		{user: "bob", from: "10.8.1.2", dest: "192.168.1.1:22", port: 9002},
+	}
+	for i := range sessions {
+		c, _ := net.Pipe()
+		b.clis[uint32(i+1)] = base.NewConn(c)
+		b.sess[uint32(i+1)] = &sessions[i]
+	}
+	_, src, _ := net.ParseCIDR("10.8.1.0/24")
+	rep := b.closeSessions(reqSess{name: "site1", close: true, by: "root", serv: 9001, src: src,
+		user: "bob", dest: "192.168.1.1:22"})
+	want := []string{"00000001", "00000002"}
+	got := rep["data"].([]string)
+	if len(got) == 2 && got[0] > got[1] {
+		got[0], got[1] = got[1], got[0]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("closed %v, want %v", got, want)
+	}
+	if len(b.clis) != len(sessions)-len(want) {
+		t.Errorf("%d sessions left, want %d", len(b.clis), len(sessions)-len(want))
+	}
+}
@@ -0,0 +1,201 @@
+package ctrl
+
+import (
+	"dk/base"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	linkStat struct { //链路测试结果
+		Site  string                 `json:"site"`
+		Time  time.Time              `json:"time"`
+		RTT   map[string]interface{} `json:"rtt"`  //往返时间分布（毫秒）及抖动
+		Down  map[string]interface{} `json:"down"` //控制端至后端的吞吐量
+		Up    map[string]interface{} `json:"up"`   //后端至控制端的吞吐量
+		Error string                 `json:"error,omitempty"`
+	}
+	linkOpts struct {
+		count    int   //RTT测试次数
+		size     int64 //吞吐量测试的字节数（每个方向）
+		duration int   //吞吐量测试的最长时间（每个方向，秒）
+	}
+)
+
+var links = struct {
+	m map[string]*linkStat //每个后端最近一次测试的结果
+	sync.Mutex
+}{m: make(map[string]*linkStat)}
+
+func lastLinkStat(name string) *linkStat {
+	links.Lock()
+	defer links.Unlock()
+	return links.m[name]
+}
+
+func throughput(bytes int64, secs float64) map[string]interface{} {
+	res := map[string]interface{}{"bytes": bytes, "time": secs}
+	if secs > 0 {
+		res["mbps"] = math.Round(float64(bytes)*8/secs/1e4) / 100
+	}
+	return res
+}
+
+func linkRTT(name string, count int) map[string]interface{} {
+	var rtts []float64
+	lost := 0
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		rep, err := callBackend(name, 12, map[string]interface{}{"op": "echo"}, 3*time.Second)
+		if err != nil || rep["stat"] != true {
+			lost++
+			continue
+		}
+		rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+	}
+	res := map[string]interface{}{"count": count, "lost": lost}
+	if len(rtts) == 0 {
+		return res
+	}
+	var sum, jitter float64
+	for i, r := range rtts {
+		sum += r
+		if i > 0 {
+			jitter += math.Abs(r - rtts[i-1])
+		}
+	}
+	if len(rtts) > 1 {
+		jitter /= float64(len(rtts) - 1)
+	}
+	res["jitter"] = jitter
+	res["avg"] = sum / float64(len(rtts))
+	sort.Float64s(rtts)
+	pct := func(p float64) float64 {
+		return rtts[int(math.Ceil(p*float64(len(rtts))))-1]
+	}
+	res["min"] = rtts[0]
+	res["p50"] = pct(0.5)
+	res["p90"] = pct(0.9)
+	res["max"] = rtts[len(rtts)-1]
+	return res
+}
+
+func linkDown(name string, opts linkOpts) (map[string]interface{}, error) {
+	call := func(op string) (map[string]interface{}, error) {
+		rep, err := callBackend(name, 12, map[string]interface{}{"op": op}, chanLife)
+		if err == nil && rep["stat"] != true {
+			err = fmt.Errorf("%v", rep["mesg"])
+		}
+		return rep, err
+	}
+	if _, err := call("begin"); err != nil {
+		return nil, err
+	}
+	conn, err := backendConn(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1+dataChunk)
+	buf[0] = 13
+	start := time.Now()
+	deadline := start.Add(time.Duration(opts.duration) * time.Second)
+	for sent := int64(0); sent < opts.size && time.Now().Before(deadline); sent += dataChunk {
+		if err := base.Reply(conn, 0, buf); err != nil {
+			return nil, err
+		}
+	}
+	rep, err := call("end") //收到回复时，后端已接收了所有数据
+	if err != nil {
+		return nil, err
+	}
+	bytes, _ := rep["data"].(map[string]interface{})["bytes"].(float64)
+	return throughput(int64(bytes), time.Since(start).Seconds()), nil
+}
+
+//linkUp 测试后端至控制端的吞吐量。为避免回复分片超出等待者的缓冲，后端
+//每次只发送一块数据，控制端同时请求多块以填满链路
+func linkUp(name string, opts linkOpts) (map[string]interface{}, error) {
+	const (
+		block = 256 * 1024 //每次请求的字节数
+		depth = 4          //同时请求的块数
+	)
+	var (
+		bytes int64
+		fail  error
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, depth)
+	start := time.Now()
+	deadline := start.Add(time.Duration(opts.duration) * time.Second)
+	for req := int64(0); req < opts.size && time.Now().Before(deadline); req += block {
+		sem <- struct{}{}
+		mu.Lock()
+		err := fail
+		mu.Unlock()
+		if err != nil {
+			break
+		}
+		size := opts.size - req
+		if size > block {
+			size = block
+		}
+		wg.Add(1)
+		go func(size int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var last []byte //最后一片为JSON格式的结果
+			err := streamBackend(name, 12, map[string]interface{}{
+				"op":       "source",
+				"size":     size,
+				"duration": opts.duration,
+			}, chanLife+time.Duration(opts.duration)*time.Second, func(p []byte) error {
+				last = p
+				return nil
+			})
+			var rep map[string]interface{}
+			if err == nil {
+				if err = json.Unmarshal(last, &rep); err == nil && rep["stat"] != true {
+					err = fmt.Errorf("%v", rep["mesg"])
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fail = err
+				return
+			}
+			n, _ := rep["data"].(map[string]interface{})["bytes"].(float64)
+			bytes += int64(n)
+		}(size)
+	}
+	wg.Wait()
+	if fail != nil {
+		return nil, fail
+	}
+	return throughput(bytes, time.Since(start).Seconds()), nil
+}
+
+//testLink 测试与后端之间的链路质量（RTT及双向吞吐量），并保存结果
+func testLink(name string, opts linkOpts) *linkStat {
+	ls := &linkStat{Site: name, Time: time.Now()}
+	ls.RTT = linkRTT(name, opts.count)
+	var err error
+	if ls.Down, err = linkDown(name, opts); err == nil {
+		ls.Up, err = linkUp(name, opts)
+	}
+	if err != nil {
+		ls.Error = err.Error()
+	}
+	base.Log("link test(%s): rtt=%v, down=%v, up=%v, error=%v", name, ls.RTT, ls.Down, ls.Up, err)
+	links.Lock()
+	links.m[name] = ls
+	links.Unlock()
+	return ls
+}
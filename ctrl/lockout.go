@@ -0,0 +1,153 @@
+package ctrl
+
+import (
+	"dk/base"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxLockTime = time.Hour      //单次锁定的最长时间
+	failForget  = 24 * time.Hour //超过该时间没有失败则清零计数
+)
+
+type (
+	failure struct {
+		count int       //连续失败次数
+		last  time.Time //最后一次失败的时间
+		until time.Time //锁定截止时间
+	}
+	lockouts struct {
+		ip    map[string]*failure
+		user  map[string]*failure
+		after int           //连续失败多少次后锁定
+		base  time.Duration //首次锁定的时间，此后每次失败加倍
+		up    time.Time     //上次清理过时记录的时间
+		sync.Mutex
+	}
+)
+
+var LO lockouts
+
+func initLockouts(cf Config) {
+	LO.Lock()
+	defer LO.Unlock()
+	LO.ip = make(map[string]*failure)
+	LO.user = make(map[string]*failure)
+	LO.after = cf.LockAfter
+	LO.base = time.Duration(cf.LockTime) * time.Second
+}
+
+//Locked 检查IP或用户是否处于锁定状态
+func (lo *lockouts) Locked(ip, user string) bool {
+	now := time.Now()
+	lo.Lock()
+	defer lo.Unlock()
+	if f := lo.ip[ip]; f != nil && now.Before(f.until) {
+		return true
+	}
+	f := lo.user[user]
+	return f != nil && now.Before(f.until)
+}
+
+//Fail 记录一次登录失败，达到阈值后按指数增长的时间锁定IP及用户
+func (lo *lockouts) Fail(ip, user string) {
+	lo.Lock()
+	defer lo.Unlock()
+	lo.prune(time.Now())
+	lo.fail(lo.ip, ip, "ip")
+	if user != "" {
+		lo.fail(lo.user, user, "user")
+	}
+}
+
+//prune 清理超过failForget没有失败的记录，避免无限增长（每分钟最多一次）
+func (lo *lockouts) prune(now time.Time) {
+	if now.Sub(lo.up) < time.Minute {
+		return
+	}
+	lo.up = now
+	for _, m := range []map[string]*failure{lo.ip, lo.user} {
+		for k, f := range m {
+			if now.Sub(f.last) > failForget {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+func (lo *lockouts) fail(m map[string]*failure, key, kind string) {
+	now := time.Now()
+	f := m[key]
+	if f == nil || now.Sub(f.last) > failForget {
+		f = &failure{}
+		m[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < lo.after {
+		return
+	}
+	lock := lo.base << uint(f.count-lo.after)
+	if lock > maxLockTime || lock <= 0 {
+		lock = maxLockTime
+	}
+	f.until = now.Add(lock)
+	base.Log("login: %s %s locked out for %v after %d failures", kind, key, lock, f.count)
+}
+
+//Pass 登录成功，清除IP及用户的失败记录
+func (lo *lockouts) Pass(ip, user string) {
+	lo.Lock()
+	defer lo.Unlock()
+	delete(lo.ip, ip)
+	delete(lo.user, user)
+}
+
+//List 列出有失败记录的IP及用户
+func (lo *lockouts) List() []map[string]interface{} {
+	now := time.Now()
+	lo.Lock()
+	defer lo.Unlock()
+	list := []map[string]interface{}{}
+	add := func(kind string, m map[string]*failure) {
+		for k, f := range m {
+			if now.Sub(f.last) > failForget {
+				delete(m, k)
+				continue
+			}
+			item := map[string]interface{}{
+				"type":     kind,
+				"key":      k,
+				"failures": f.count,
+				"last":     f.last.Format(time.RFC3339),
+			}
+			if now.Before(f.until) {
+				item["until"] = f.until.Format(time.RFC3339)
+			}
+			list = append(list, item)
+		}
+	}
+	add("ip", lo.ip)
+	add("user", lo.user)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["last"].(string) > list[j]["last"].(string)
+	})
+	return list
+}
+
+//Clear 清除IP或用户的失败记录及锁定，key为空表示全部清除
+func (lo *lockouts) Clear(key string) bool {
+	lo.Lock()
+	defer lo.Unlock()
+	if key == "" {
+		lo.ip = make(map[string]*failure)
+		lo.user = make(map[string]*failure)
+		return true
+	}
+	found := lo.ip[key] != nil || lo.user[key] != nil
+	delete(lo.ip, key)
+	delete(lo.user, key)
+	return found
+}
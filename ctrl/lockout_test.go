@@ -0,0 +1,32 @@
+package ctrl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPrune(t *testing.T) {
+	lo := lockouts{ip: make(map[string]*failure), user: make(map[string]*failure), after: 3, base: time.Second}
+	old := time.Now().Add(-failForget - time.Minute)
+	lo.ip["1.2.3.4"] = &failure{count: 5, last: old}
+	lo.user["bob"] = &failure{count: 2, last: old}
+	lo.user["carol"] = &failure{count: 1, last: time.Now()}
+	lo.Fail("1.2.3.5", "")
+	if lo.ip["1.2.3.4"] != nil || lo.user["bob"] != nil {
+		t.Errorf("expired records not pruned: ip=%v, user=%v", lo.ip, lo.user)
+	}
+	if lo.user["carol"] == nil || lo.ip["1.2.3.5"] == nil {
+		t.Errorf("recent records pruned: ip=%v, user=%v", lo.ip, lo.user)
+	}
+	lo.user["bob"] = &failure{count: 2, last: old}
+	lo.Fail("1.2.3.5", "") //一分钟内不重复清理
+	if lo.user["bob"] == nil {
+		t.Errorf("pruned again within a minute")
+	}
+	for i := 0; i < 2; i++ {
+		lo.Fail("1.2.3.5", "dave")
+	}
+	if !lo.Locked("1.2.3.5", "") || lo.Locked("1.2.3.6", "dave") {
+		t.Errorf("ip 1.2.3.5 should be locked after 4 failures, user dave after 2 should not")
+	}
+}
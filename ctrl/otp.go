@@ -0,0 +1,74 @@
+package ctrl
+
+import (
+	"crypto/subtle"
+	"dk/base"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+const otpPeriod = 30 //TOTP时间步长（秒）
+
+//otpSteps 记录每个用户最后一次被接受的TOTP时间步，防止同一密码被重复使用
+type otpSteps struct {
+	last map[string]int64
+	file string
+	sync.Mutex
+}
+
+var OS otpSteps
+
+func initOTPState(file string) {
+	OS.Lock()
+	defer OS.Unlock()
+	OS.last = make(map[string]int64)
+	OS.file = file
+	if buf, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(buf, &OS.last); err != nil {
+			base.Log("otp state: %v", err)
+			OS.last = make(map[string]int64)
+		}
+	}
+}
+
+//save 保存各用户的时间步，使其在网关重启后仍然有效（调用者须持有锁）
+func (s *otpSteps) save() {
+	buf, _ := json.Marshal(s.last)
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		base.Log("otp state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		base.Log("otp state: %v", err)
+	}
+}
+
+//Validate 校验用户的TOTP密码（允许前后各一个时间步的偏差），
+//其时间步须晚于该用户上次被接受的时间步
+func (s *otpSteps) Validate(usr, passcode, secret string) bool {
+	now := time.Now()
+	for _, skew := range []int64{0, -1, 1} {
+		t := now.Add(time.Duration(skew*otpPeriod) * time.Second)
+		code, err := totp.GenerateCode(secret, t)
+		if err != nil || subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) != 1 {
+			continue
+		}
+		step := t.Unix() / otpPeriod
+		s.Lock()
+		defer s.Unlock()
+		if step <= s.last[usr] {
+			base.Log("login: replayed OTP for user %s rejected", usr)
+			return false
+		}
+		s.last[usr] = step
+		s.save()
+		return true
+	}
+	return false
+}
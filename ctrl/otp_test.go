@@ -0,0 +1,44 @@
+package ctrl
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestOTPReplay(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	s := otpSteps{last: make(map[string]int64), file: filepath.Join(t.TempDir(), "otp_state.json")}
+	now := time.Now()
+	code := func(step int) string {
+		c, err := totp.GenerateCode(secret, now.Add(time.Duration(step*otpPeriod)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	cases := []struct {
+		usr, code string
+		want      bool
+	}{
+		{"alice", "000000x", false},
+		{"alice", code(0), true},
+		{"alice", code(0), false},  //同一密码重复使用
+		{"alice", code(-1), false}, //早于已接受的时间步
+		{"bob", code(0), true},     //各用户分别记录
+		{"alice", code(1), true},
+		{"alice", code(1), false},
+		{"alice", code(5), false}, //超出允许的偏差
+	}
+	for i, c := range cases {
+		if got := s.Validate(c.usr, c.code, secret); got != c.want {
+			t.Errorf("#%d Validate(%s, %s) = %v, want %v", i, c.usr, c.code, got, c.want)
+		}
+	}
+	initOTPState(s.file) //时间步须在重启后仍然有效
+	if OS.last["alice"] != s.last["alice"] || OS.last["bob"] != s.last["bob"] {
+		t.Errorf("saved steps %v, want %v", OS.last, s.last)
+	}
+}
@@ -0,0 +1,222 @@
+package ctrl
+
+import (
+	"dk/base"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Policy 用户的访问策略，各项为空表示不限
+type Policy struct {
+	Sites    []string `yaml:"sites"`    //允许访问的后端
+	Hosts    []string `yaml:"hosts"`    //允许连接的目标（CIDR或IP）
+	Ports    []string `yaml:"ports"`    //允许连接的端口（端口或"起始-结束"）
+	Scan     bool     `yaml:"scan"`     //是否允许端口扫描
+	Manage   bool     `yaml:"manage"`   //是否允许远程管理（执行命令、文件传输、修改配置、更新程序及自定义命令）
+	Delegate []string `yaml:"delegate"` //允许为其他来源（IP或CIDR）申请授权的网段（为空表示不允许）
+	nets     []*net.IPNet
+	ranges   [][2]uint16
+	dnets    []*net.IPNet
+}
+
+//Compile 校验并解析策略，须在使用前调用
+func (p *Policy) Compile() error {
+	p.nets, p.ranges, p.dnets = nil, nil, nil
+	for _, d := range p.Delegate {
+		n, err := parseNet(d)
+		if err != nil {
+			return err
+		}
+		p.dnets = append(p.dnets, n)
+	}
+	for i, s := range p.Sites {
+		p.Sites[i] = strings.ToLower(strings.TrimSpace(s))
+	}
+	for _, h := range p.Hosts {
+		n, err := parseNet(h)
+		if err != nil {
+			return err
+		}
+		p.nets = append(p.nets, n)
+	}
+	for _, r := range p.Ports {
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i > 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		l, e1 := strconv.Atoi(strings.TrimSpace(lo))
+		h, e2 := strconv.Atoi(strings.TrimSpace(hi))
+		if e1 != nil || e2 != nil || l <= 0 || h > 65535 || l > h {
+			return fmt.Errorf("invalid port `%s`", r)
+		}
+		p.ranges = append(p.ranges, [2]uint16{uint16(l), uint16(h)})
+	}
+	return nil
+}
+
+//parseNet 解析CIDR或IP（视为只包含该IP的网段）
+func parseNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP `%s`", s)
+		}
+		return hostNet(ip), nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR `%s`", s)
+	}
+	return n, nil
+}
+
+func (p *Policy) site(name string) bool {
+	if len(p.Sites) == 0 {
+		return true
+	}
+	for _, s := range p.Sites {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) host(ip net.IP) bool {
+	if len(p.nets) == 0 {
+		return true
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) port(port uint16) bool {
+	if len(p.ranges) == 0 {
+		return true
+	}
+	for _, r := range p.ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+//policies 返回适用于用户的策略（用户本身及其所属组），为nil表示不受限制。
+//本机以PID访问者不受限制
+func (cf Config) policies(usr string) []*Policy {
+	if usr == "" {
+		return nil
+	}
+	var ps []*Policy
+	if p := cf.Policies[usr]; p != nil {
+		ps = append(ps, p)
+	}
+	for g, members := range cf.Groups {
+		for _, m := range members {
+			if m == usr {
+				if p := cf.Policies["@"+g]; p != nil {
+					ps = append(ps, p)
+				}
+				break
+			}
+		}
+	}
+	return ps
+}
+
+//permitSite 用户是否可以访问后端（任一策略允许即可）
+func (cf Config) permitSite(usr, site string) bool {
+	ps := cf.policies(usr)
+	if ps == nil {
+		return true
+	}
+	for _, p := range ps {
+		if p.site(site) {
+			return true
+		}
+	}
+	return false
+}
+
+//permitConn 用户是否可以通过后端连接目标
+func (cf Config) permitConn(usr, site string, ip net.IP, port uint16) bool {
+	ps := cf.policies(usr)
+	if ps == nil {
+		return true
+	}
+	for _, p := range ps {
+		if p.site(site) && p.host(ip) && p.port(port) {
+			return true
+		}
+	}
+	return false
+}
+
+//permitSource 用户是否可以为来源src（非本人IP）申请授权（管理员，或有策略的
+//delegate明确允许；没有策略的用户也不能代他人申请）
+func (cf Config) permitSource(usr string, src *net.IPNet) bool {
+	if isAdmin(cf, usr) {
+		return true
+	}
+	for _, p := range cf.policies(usr) {
+		for _, n := range p.dnets {
+			if n.Contains(src.IP) && prefixLen(src) >= prefixLen(n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//permitScan 用户是否可以在后端扫描端口
+func (cf Config) permitScan(usr, site string, port uint16) bool {
+	ps := cf.policies(usr)
+	if ps == nil {
+		return true
+	}
+	for _, p := range ps {
+		if p.Scan && p.site(site) && p.port(port) {
+			return true
+		}
+	}
+	return false
+}
+
+//permitManage 用户是否可以远程管理后端（管理员，或有策略明确允许）
+func (cf Config) permitManage(usr, site string) bool {
+	if isAdmin(cf, usr) {
+		return true
+	}
+	for _, p := range cf.policies(usr) {
+		if p.Manage && p.site(site) {
+			return true
+		}
+	}
+	return false
+}
+
+//siteUser 校验请求者身份及其访问后端site的权限（manage为true时须有远程管理权限），
+//未通过时回复错误
+func siteUser(w http.ResponseWriter, r *http.Request, cf Config, site string, manage bool) (string, bool) {
+	usr, ok := identify(r)
+	if !ok {
+		return "", false
+	}
+	if manage && !cf.permitManage(usr, site) || !cf.permitSite(usr, site) {
+		base.Log("%s %s by %s denied by policy", r.Method, r.URL.Path, usr)
+		jsonReply(w, map[string]interface{}{
+			"stat": false,
+			"mesg": "access denied by policy",
+		})
+		return "", false
+	}
+	return usr, true
+}
@@ -0,0 +1,193 @@
+package ctrl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPolicyCompile(t *testing.T) {
+	cases := []struct {
+		name string
+		p    Policy
+		ok   bool
+	}{
+		{"empty", Policy{}, true},
+		{"full", Policy{
+			Sites:    []string{" Site1 "},
+			Hosts:    []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"},
+			Ports:    []string{"22", "8000-8080", " 443 "},
+			Delegate: []string{"10.8.0.0/16"},
+		}, true},
+		{"bad host", Policy{Hosts: []string{"10.0.0"}}, false},
+		{"bad cidr", Policy{Hosts: []string{"10.0.0.0/33"}}, false},
+		{"bad delegate", Policy{Delegate: []string{"x"}}, false},
+		{"port zero", Policy{Ports: []string{"0"}}, false},
+		{"port too large", Policy{Ports: []string{"65536"}}, false},
+		{"reversed range", Policy{Ports: []string{"90-80"}}, false},
+		{"open range", Policy{Ports: []string{"80-"}}, false},
+		{"not a port", Policy{Ports: []string{"http"}}, false},
+	}
+	for _, c := range cases {
+		err := c.p.Compile()
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+	p := Policy{Sites: []string{" Site1 "}, Ports: []string{"8000-8080"}}
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.site("site1") || p.site("site2") {
+		t.Errorf("site names not normalized: %q", p.Sites)
+	}
+	if !p.port(8000) || !p.port(8080) || p.port(7999) || p.port(8081) {
+		t.Errorf("port range 8000-8080 not inclusive: %v", p.ranges)
+	}
+}
+
+//testConfig 返回用于测试的网关配置：root为管理员，bob和@ops有策略（只有bob可以代他人申请），
+//dave没有策略
+func testConfig(t *testing.T) Config {
+	cf := Config{
+		Admins: []string{"root"},
+		Groups: map[string][]string{"ops": {"carol", "bob"}},
+		Policies: map[string]*Policy{
+			"bob": {
+				Sites:    []string{"site1"},
+				Hosts:    []string{"10.0.0.0/8"},
+				Ports:    []string{"22", "8000-8080"},
+				Delegate: []string{"10.8.0.0/16"},
+			},
+			"@ops": {
+				Sites:  []string{"site2"},
+				Hosts:  []string{"192.168.1.1"},
+				Scan:   true,
+				Manage: true,
+			},
+		},
+	}
+	for k, p := range cf.Policies {
+		if err := p.Compile(); err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+	}
+	return cf
+}
+
+func TestPermitSite(t *testing.T) {
+	cf := testConfig(t)
+	cases := []struct {
+		usr, site string
+		want      bool
+	}{
+		{"", "site3", true}, //本机以PID访问
+		{"root", "site3", true},
+		{"dave", "site3", true}, //没有策略则不受限制
+		{"bob", "site1", true},
+		{"bob", "site2", true}, //经由@ops
+		{"bob", "site3", false},
+		{"carol", "site2", true},
+		{"carol", "site1", false},
+	}
+	for _, c := range cases {
+		if got := cf.permitSite(c.usr, c.site); got != c.want {
+			t.Errorf("permitSite(%q, %q) = %v, want %v", c.usr, c.site, got, c.want)
+		}
+	}
+}
+
+func TestPermitConn(t *testing.T) {
+	cf := testConfig(t)
+	cases := []struct {
+		usr, site, ip string
+		port          uint16
+		want          bool
+	}{
+		{"", "site1", "1.2.3.4", 3389, true},
+		{"dave", "site1", "1.2.3.4", 3389, true},
+		{"bob", "site1", "10.1.2.3", 22, true},
+		{"bob", "site1", "10.1.2.3", 8080, true},
+		{"bob", "site1", "10.1.2.3", 8081, false},
+		{"bob", "site1", "11.1.2.3", 22, false},
+		{"bob", "site2", "10.1.2.3", 22, false}, //@ops只允许192.168.1.1
+		{"bob", "site2", "192.168.1.1", 3389, true},
+		{"carol", "site2", "192.168.1.1", 1, true},
+		{"carol", "site2", "192.168.1.2", 1, false},
+		{"carol", "site1", "192.168.1.1", 1, false},
+	}
+	for _, c := range cases {
+		got := cf.permitConn(c.usr, c.site, net.ParseIP(c.ip), c.port)
+		if got != c.want {
+			t.Errorf("permitConn(%q, %q, %s, %d) = %v, want %v", c.usr, c.site, c.ip, c.port, got, c.want)
+		}
+	}
+}
+
+func TestPermitScan(t *testing.T) {
+	cf := testConfig(t)
+	cases := []struct {
+		usr, site string
+		port      uint16
+		want      bool
+	}{
+		{"", "site1", 80, true},
+		{"dave", "site1", 80, true},
+		{"bob", "site1", 22, false}, //bob本人的策略不允许扫描
+		{"bob", "site2", 22, true},
+		{"carol", "site2", 80, true},
+		{"carol", "site1", 80, false},
+	}
+	for _, c := range cases {
+		if got := cf.permitScan(c.usr, c.site, c.port); got != c.want {
+			t.Errorf("permitScan(%q, %q, %d) = %v, want %v", c.usr, c.site, c.port, got, c.want)
+		}
+	}
+}
+
+func TestPermitManage(t *testing.T) {
+	cf := testConfig(t)
+	cases := []struct {
+		usr, site string
+		want      bool
+	}{
+		{"", "site1", true},
+		{"root", "site1", true},
+		{"dave", "site1", false}, //远程管理须明确允许
+		{"bob", "site1", false},
+		{"bob", "site2", true},
+		{"carol", "site2", true},
+		{"carol", "site1", false},
+	}
+	for _, c := range cases {
+		if got := cf.permitManage(c.usr, c.site); got != c.want {
+			t.Errorf("permitManage(%q, %q) = %v, want %v", c.usr, c.site, got, c.want)
+		}
+	}
+}
+
+func TestPermitSource(t *testing.T) {
+	cf := testConfig(t)
+	cases := []struct {
+		usr, src string
+		want     bool
+	}{
+		{"", "1.2.3.0/24", true},
+		{"root", "1.2.3.0/24", true},
+		{"dave", "1.2.3.0/24", false}, //没有策略也不能代他人申请
+		{"carol", "10.8.1.0/24", false},
+		{"bob", "10.8.1.0/24", true},
+		{"bob", "10.8.0.0/16", true},
+		{"bob", "10.8.3.4/32", true},
+		{"bob", "10.0.0.0/8", false}, //大于delegate网段
+		{"bob", "10.9.0.1/32", false},
+	}
+	for _, c := range cases {
+		_, src, err := net.ParseCIDR(c.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cf.permitSource(c.usr, src); got != c.want {
+			t.Errorf("permitSource(%q, %s) = %v, want %v", c.usr, c.src, got, c.want)
+		}
+	}
+}
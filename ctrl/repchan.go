@@ -10,7 +10,7 @@ const chanLife = 9 * time.Second
 type (
 	replyChan struct {
 		c chan interface{}
-		t time.Time
+		t time.Time //过期时间
 	}
 	replyChans map[uint32]*replyChan
 )
@@ -22,17 +22,26 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func setChan(c chan interface{}) uint32 {
+func setChan(c chan interface{}, life time.Duration) uint32 {
 	idx := rand.Uint32()
-	rc[idx] = &replyChan{c, time.Now()}
+	rc[idx] = &replyChan{c, time.Now().Add(life)}
 	return idx
 }
 
+//peekChan 获取回复通道但不删除（用于分片回复）
+func peekChan(idx uint32) chan interface{} {
+	r := rc[idx]
+	if r == nil {
+		return nil
+	}
+	return r.c
+}
+
 func getChan(idx uint32) chan interface{} {
 	r := rc[idx]
 	delete(rc, idx)
 	for i, c := range rc {
-		if time.Since(c.t) > chanLife {
+		if time.Now().After(c.t) {
 			delete(rc, i)
 		}
 	}
@@ -12,12 +12,39 @@ func notFound(w http.ResponseWriter, r *http.Request) {
 func setupRoutes(cf Config) {
 	http.HandleFunc("/", home(cf))
 	http.HandleFunc("/dk/login", apiLogin(cf))
-	http.HandleFunc("/dk/auth", apiAuth)
-	http.HandleFunc("/dk/site", apiSite)
+	http.HandleFunc("/dk/logout", apiLogout)
+	http.HandleFunc("/dk/token", apiToken)
+	http.HandleFunc("/dk/token/", apiToken)
+	http.HandleFunc("/dk/lockout", apiLockout(cf))
+	http.HandleFunc("/dk/lockout/", apiLockout(cf))
+	http.HandleFunc("/dk/audit", apiAudit(cf))
+	http.HandleFunc("/dk/auth", apiAuth(cf))
+	http.HandleFunc("/dk/auth/", apiAuth(cf))
+	http.HandleFunc("/dk/site", apiSite(cf))
+	http.HandleFunc("/dk/site/", apiSessions(cf))
 	http.HandleFunc("/dk/port", notFound)
-	http.HandleFunc("/dk/port/", apiScan)
+	http.HandleFunc("/dk/port/", apiScan(cf))
 	http.HandleFunc("/dk/conn", notFound)
-	http.HandleFunc("/dk/conn/", apiConn)
+	http.HandleFunc("/dk/conn/", apiConn(cf))
+	http.HandleFunc("/dk/diag", notFound)
+	http.HandleFunc("/dk/diag/", apiDiag(cf))
+	http.HandleFunc("/dk/wol", notFound)
+	http.HandleFunc("/dk/wol/", apiWol(cf))
+	http.HandleFunc("/dk/conf", notFound)
+	http.HandleFunc("/dk/conf/", apiConf(cf))
+	http.HandleFunc("/dk/update", apiUpdate(cf))
+	http.HandleFunc("/dk/update/", apiUpdate(cf))
+	http.HandleFunc("/dk/log", notFound)
+	http.HandleFunc("/dk/log/", apiLog(cf))
+	http.HandleFunc("/dk/exec", notFound)
+	http.HandleFunc("/dk/exec/", apiExec(cf))
+	http.HandleFunc("/dk/file", notFound)
+	http.HandleFunc("/dk/file/", apiFile(cf))
+	http.HandleFunc("/dk/xfer", apiXfer(cf))
+	http.HandleFunc("/dk/link", notFound)
+	http.HandleFunc("/dk/link/", apiLink(cf))
+	http.HandleFunc("/dk/cmd", notFound)
+	http.HandleFunc("/dk/cmd/", apiCmd(cf))
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, filepath.Join(cf.WebRoot, "imgs/favicon.png"))
 	})
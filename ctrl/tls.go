@@ -0,0 +1,97 @@
+package ctrl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//loadCert 加载管理端口的TLS证书。若证书及私钥文件均不存在，则生成自签名证书并保存
+func loadCert(cf Config) (tls.Certificate, error) {
+	_, ec := os.Stat(cf.TLSCert)
+	_, ek := os.Stat(cf.TLSKey)
+	if os.IsNotExist(ec) && os.IsNotExist(ek) {
+		if err := genCert(cf.TLSCert, cf.TLSKey); err != nil {
+			return tls.Certificate{}, err
+		}
+	} else if os.IsNotExist(ec) != os.IsNotExist(ek) {
+		return tls.Certificate{}, errors.New("tls_cert and tls_key must be both present or both absent")
+	}
+	return tls.LoadX509KeyPair(cf.TLSCert, cf.TLSKey)
+}
+
+func genCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	sn, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	tpl := x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"Door Keeper"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host != "" {
+		tpl.DNSNames = append(tpl.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+//fingerprint 返回证书的SHA-256指纹（冒号分隔的十六进制），供用户核对自签名证书
+func fingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	hs := make([]string, len(sum))
+	for i, b := range sum {
+		hs[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hs, ":")
+}
+
+//redirectHTTPS 将HTTP请求重定向到管理端口的HTTPS地址
+func redirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		url := "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusPermanentRedirect)
+	})
+}
@@ -1,82 +1,193 @@
 package ctrl
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"crypto/sha256"
+	"dk/base"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
 type (
 	token struct {
-		ex time.Time
-		ip net.IP
+		User    string    `json:"user"`
+		IP      net.IP    `json:"ip"`
+		Created time.Time `json:"created"`
+		Expiry  time.Time `json:"expiry"`
 	}
 	tokens struct {
-		reg map[string]*token
-		exp time.Duration
+		reg  map[string]*token //索引为令牌的SHA-256（十六进制），不保存令牌原文
+		exp  time.Duration
+		file string
 		sync.Mutex
 	}
 )
 
 var TS tokens
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+//newToken 生成256位随机令牌（URL安全的base64编码）
+func newToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func tokenKey(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
 }
 
-func uuid(L int) string {
-	cs := "0123456789abcdefghijklmnopqrstuvwxyz"
-	buf := make([]byte, L)
-	rand.Read(buf)
-	for i := 0; i < L; i++ {
-		buf[i] = cs[buf[i]%36]
-	}
-	return string(buf)
+//reqToken 返回请求携带的令牌（查询参数t或同名cookie）
+func reqToken(r *http.Request) string {
+	tok := r.URL.Query().Get("t")
+	if tok == "" {
+		tok = getCookie(r, "t")
+	}
+	return tok
 }
 
-func initTokenStore(ttl int) {
+func initTokenStore(ttl int, file string) {
 	TS.Lock()
 	defer TS.Unlock()
 	TS.reg = make(map[string]*token)
 	TS.exp = time.Duration(ttl) * time.Second
+	TS.file = file
+	if buf, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(buf, &TS.reg); err != nil {
+			base.Log("tokens: %v", err)
+			TS.reg = make(map[string]*token)
+		}
+	}
+	TS.purge()
 	go func() {
 		for {
 			time.Sleep(time.Minute)
-			now := time.Now()
-			func() {
-				TS.Lock()
-				defer TS.Unlock()
-				for t, k := range TS.reg {
-					if now.After(k.ex) {
-						delete(TS.reg, t)
-					}
-				}
-			}()
+			TS.Lock()
+			TS.purge()
+			TS.Unlock()
 		}
 	}()
 }
 
-func (ts *tokens) Set(r *http.Request) (string, time.Time) {
-	tok := uuid(8)
-	exp := time.Now().Add(ts.exp)
+//purge 删除过期令牌（调用者须持有锁）
+func (ts *tokens) purge() {
+	now := time.Now()
+	changed := false
+	for k, t := range ts.reg {
+		if now.After(t.Expiry) {
+			delete(ts.reg, k)
+			changed = true
+		}
+	}
+	if changed {
+		ts.save()
+	}
+}
+
+//save 将令牌写入文件，使其在网关重启后仍然有效（调用者须持有锁）
+func (ts *tokens) save() {
+	if ts.file == "" {
+		return
+	}
+	buf, _ := json.Marshal(ts.reg)
+	tmp := ts.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		base.Log("tokens: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, ts.file); err != nil {
+		base.Log("tokens: %v", err)
+	}
+}
+
+func (ts *tokens) Set(r *http.Request, user string) (string, time.Time) {
+	tok := newToken()
+	now := time.Now()
+	exp := now.Add(ts.exp)
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
 	ts.Lock()
 	defer ts.Unlock()
-	ts.reg[tok] = &token{ex: exp, ip: net.ParseIP(host)}
+	ts.reg[tokenKey(tok)] = &token{User: user, IP: net.ParseIP(host), Created: now, Expiry: exp}
+	ts.save()
 	return tok, exp
 }
 
-func (ts *tokens) Get(r *http.Request) bool {
-	tok := r.URL.Query().Get("t")
+//Get 校验请求携带的令牌，返回令牌所属用户
+func (ts *tokens) Get(r *http.Request) (string, bool) {
+	tok := reqToken(r)
 	if tok == "" {
-		tok = getCookie(r, "t")
+		return "", false
 	}
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
 	ip := net.ParseIP(host)
 	ts.Lock()
 	defer ts.Unlock()
-	t := ts.reg[tok]
-	return t != nil && t.ip != nil && t.ip.Equal(ip) && time.Now().Before(t.ex)
+	t := ts.reg[tokenKey(tok)]
+	if t == nil || t.IP == nil || !t.IP.Equal(ip) || !time.Now().Before(t.Expiry) {
+		return "", false
+	}
+	return t.User, true
+}
+
+//List 列出用户的有效令牌，cur为当前请求的令牌（在列表中标出）。
+//令牌以其散列值的前16位标识，不返回令牌原文
+func (ts *tokens) List(user, cur string) []map[string]interface{} {
+	ck := tokenKey(cur)
+	ts.Lock()
+	defer ts.Unlock()
+	list := []map[string]interface{}{}
+	for k, t := range ts.reg {
+		if t.User != user || time.Now().After(t.Expiry) {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"id":      k[:16],
+			"ip":      t.IP.String(),
+			"created": t.Created.Format(time.RFC3339),
+			"expiry":  t.Expiry.Format(time.RFC3339),
+			"current": k == ck,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["created"].(string) < list[j]["created"].(string)
+	})
+	return list
+}
+
+//Revoke 撤销用户的令牌，id为List返回的标识
+func (ts *tokens) Revoke(user, id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	ts.Lock()
+	defer ts.Unlock()
+	for k, t := range ts.reg {
+		if k[:16] == id && t.User == user {
+			delete(ts.reg, k)
+			ts.save()
+			return true
+		}
+	}
+	return false
+}
+
+//Drop 撤销令牌原文为tok的令牌（用于注销）
+func (ts *tokens) Drop(tok string) {
+	ts.Lock()
+	defer ts.Unlock()
+	k := tokenKey(tok)
+	if ts.reg[k] != nil {
+		delete(ts.reg, k)
+		ts.save()
+	}
 }
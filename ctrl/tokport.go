@@ -0,0 +1,238 @@
+package ctrl
+
+import (
+	"crypto/tls"
+	"dk/base"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//tgs 令牌授权：经共享的令牌端口接入的连接按令牌（而非来源IP）转发，
+//索引为令牌的SHA-256（十六进制），不保存令牌原文
+var tgs = struct {
+	m map[string]*authReq
+	sync.Mutex
+}{m: make(map[string]*authReq)}
+
+var tokenFP string //令牌端口TLS证书的SHA-256指纹
+
+//issueGrant 为授权ar生成令牌
+func issueGrant(ar authReq) string {
+	tok := newToken()
+	tgs.Lock()
+	defer tgs.Unlock()
+	for k, a := range tgs.m { //清理过期授权
+		if time.Now().After(a.time) {
+			delete(tgs.m, k)
+		}
+	}
+	tgs.m[tokenKey(tok)] = &ar
+	return tok
+}
+
+//grantOf 返回令牌对应的有效授权及其标识
+func grantOf(tok string) (*authReq, string) {
+	k := tokenKey(tok)
+	tgs.Lock()
+	defer tgs.Unlock()
+	a := tgs.m[k]
+	if a == nil || time.Now().After(a.time) {
+		return nil, ""
+	}
+	return a, k[:16]
+}
+
+//grantToken 为申请ar生成令牌授权并回复（rec为审计记录）
+func grantToken(w http.ResponseWriter, cf Config, rec auditRec, ar authReq) {
+	if cf.TokenPort <= 0 {
+		jsonReply(w, map[string]interface{}{
+			"stat": false,
+			"mesg": "token port not enabled",
+		})
+		return
+	}
+	ch := make(chan interface{}, 1)
+	br <- reqList{name: ar.name, rep: ch}
+	select {
+	case rep := <-ch:
+		if len(rep.(map[string]interface{})["data"].([]map[string]interface{})) == 0 {
+			rec.Mesg = "no such backend: " + ar.name
+			audit(rec)
+			jsonReply(w, map[string]interface{}{"stat": false, "mesg": rec.Mesg})
+			return
+		}
+	case <-time.After(chanLife):
+		rec.Mesg = "query backend timeout"
+		audit(rec)
+		jsonReply(w, map[string]interface{}{"stat": false, "mesg": rec.Mesg})
+		return
+	}
+	ar.time = time.Now().Add(time.Duration(cf.AuthTime) * time.Second)
+	tok := issueGrant(ar)
+	rec.OK, rec.Port, rec.Source = true, cf.TokenPort, "token "+tokenKey(tok)[:16]
+	audit(rec)
+	jsonReply(w, map[string]interface{}{
+		"stat": true,
+		"data": map[string]interface{}{
+			"port":  cf.TokenPort,
+			"token": tok,
+			"pin":   tokenFP,
+			"until": ar.time.Format(time.RFC3339),
+		},
+		"mesg": fmt.Sprintf("connect to port %d with token", cf.TokenPort),
+	})
+}
+
+//listGrants 列出用户（all为true时为所有用户）的有效令牌授权，令牌以其散列值的前16位标识
+func listGrants(port int, usr string, all bool) []map[string]interface{} {
+	tgs.Lock()
+	defer tgs.Unlock()
+	list := []map[string]interface{}{}
+	for k, a := range tgs.m {
+		if time.Now().After(a.time) || (!all && a.user != usr) {
+			continue
+		}
+		g := map[string]interface{}{
+			"port":  port,
+			"id":    k[:16],
+			"site":  a.name,
+			"addr":  fmt.Sprintf("%s:%d", a.host, a.port),
+			"until": a.time.Format(time.RFC3339),
+		}
+		if all {
+			g["from"] = a.from.String()
+			g["user"] = a.user
+		}
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["until"].(string) < list[j]["until"].(string)
+	})
+	return list
+}
+
+//manageGrant 撤销或延期令牌授权（ar.secs为延期秒数），id为listGrants返回的标识
+func manageGrant(ar authReq, id string, cf Config) map[string]interface{} {
+	tgs.Lock()
+	var key string
+	var a *authReq
+	for k, g := range tgs.m {
+		if k[:16] == id && time.Now().Before(g.time) {
+			key, a = k, g
+			break
+		}
+	}
+	if a == nil {
+		tgs.Unlock()
+		return map[string]interface{}{
+			"stat": false,
+			"mesg": fmt.Sprintf("no token auth '%s'", id),
+		}
+	}
+	if !ar.admin && a.user != ar.user {
+		tgs.Unlock()
+		return map[string]interface{}{"stat": false, "mesg": "not your auth"}
+	}
+	by := ar.user
+	if by == "" {
+		by = "(local)"
+	}
+	rec := auditRec{OK: true, User: a.user, From: a.from.String(), Source: "token " + id,
+		Site: a.name, Dest: fmt.Sprintf("%s:%d", a.host, a.port), Port: cf.TokenPort}
+	if ar.op == authRevoke {
+		delete(tgs.m, key)
+		tgs.Unlock()
+		base.Log("[token#%s] auth revoked by %s", id, by)
+		rec.Event, rec.Mesg = "revoke", "revoked by "+by
+		audit(rec)
+		br <- reqSess{name: a.name, close: true, by: by, tid: id, rep: make(chan interface{}, 1)}
+		return map[string]interface{}{"stat": true}
+	}
+	secs := ar.secs
+	if secs <= 0 || secs > cf.AuthTime {
+		secs = cf.AuthTime
+	}
+	until := time.Now().Add(time.Duration(secs) * time.Second)
+	if until.After(a.time) { //延期不会缩短授权
+		na := *a
+		na.time = until
+		tgs.m[key] = &na
+		base.Log("[token#%s] auth extended to %s by %s", id, until.Format(time.RFC3339), by)
+		rec.Event, rec.Mesg = "extend", "extended by "+by
+		audit(rec)
+	} else {
+		until = a.time
+	}
+	tgs.Unlock()
+	return map[string]interface{}{
+		"stat": true,
+		"data": map[string]interface{}{"until": until.Format(time.RFC3339)},
+	}
+}
+
+//startTokenPort 在共享的令牌端口（TLS，与管理端口使用相同的证书）上接受连接：
+//客户端首先发送令牌前导，网关按令牌对应的授权转发连接。连接的来源仍须在授权的
+//来源网段内，同一IP后的多个用户可以各自使用不同的令牌
+func startTokenPort(cf Config) {
+	handshake := time.Duration(cf.Handshake) * time.Second
+	cert, err := loadCert(cf)
+	assert(err)
+	tokenFP = fingerprint(cert)
+	ln, err := tls.Listen("tcp", fmt.Sprintf(":%d", cf.TokenPort), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	assert(err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				base.Log("accept(token): %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			go func(c net.Conn) {
+				ra := c.RemoteAddr().String()
+				c.SetReadDeadline(time.Now().Add(handshake))
+				tok, err := base.ReadTokenPreface(c)
+				c.SetReadDeadline(time.Time{})
+				if err != nil {
+					base.Log("[token] %s: %v", ra, err)
+					c.Close()
+					return
+				}
+				ar, id := grantOf(tok)
+				if ar == nil {
+					base.Log("[token] %s: invalid or expired token", ra)
+					c.Close()
+					return
+				}
+				if ip := c.RemoteAddr().(*net.TCPAddr).IP; !ar.src.Contains(ip) {
+					base.Log("[token#%s] %s: source not in %s", id, ra, ar.src)
+					audit(auditRec{Event: "open", User: ar.user, From: ip.String(), Source: "token " + id,
+						Site: ar.name, Dest: fmt.Sprintf("%s:%d", ar.host, ar.port), Port: cf.TokenPort,
+						Mesg: "source not allowed"})
+					c.Close()
+					return
+				}
+				dest := make([]byte, 2)
+				binary.BigEndian.PutUint16(dest, ar.port)
+				dest = append(dest, ar.host...)
+				br <- reqConn{
+					session: rand.Uint32(),
+					backend: ar.name,
+					dest:    dest,
+					user:    ar.user,
+					tid:     id,
+					conn:    c,
+				}
+			}(conn)
+		}
+	}()
+}
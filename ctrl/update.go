@@ -0,0 +1,168 @@
+package ctrl
+
+import (
+	"crypto/sha256"
+	"dk/base"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	updSync  = 64          //每发送若干数据块后与后端同步一次进度
+	updGrace = time.Minute //重连期限过后仍接受回滚的报告
+)
+
+type (
+	updateStat struct { //后端自我更新的进度
+		Size  int64     `json:"size"`
+		Sent  int64     `json:"sent"` //已发送的字节数
+		Recv  int64     `json:"recv"` //后端确认收到的字节数
+		Stat  string    `json:"stat"` //uploading、restarting、completed、rollback或failed
+		Mesg  string    `json:"mesg,omitempty"`
+		Start time.Time `json:"start"`
+		Until time.Time `json:"until"` //等待后端重连的期限
+		Ver   string    `json:"version,omitempty"`
+		Sum   string    `json:"sha256"` //新程序的SHA256
+	}
+)
+
+var updates = struct {
+	m map[string]*updateStat
+	sync.Mutex
+}{m: make(map[string]*updateStat)}
+
+func updateProgress(name string) interface{} {
+	updates.Lock()
+	defer updates.Unlock()
+	if name != "" {
+		us := updates.m[name]
+		if us == nil {
+			return nil
+		}
+		s := *us
+		return s
+	}
+	ss := make(map[string]updateStat)
+	for n, us := range updates.m {
+		ss[n] = *us
+	}
+	return ss
+}
+
+func setProgress(name string, set func(*updateStat)) {
+	updates.Lock()
+	defer updates.Unlock()
+	us := updates.m[name]
+	if us != nil {
+		set(us)
+	}
+}
+
+//updateOnline 后端（重新）报告元数据时调用，按其可执行文件的SHA256确认自我更新是否成功。
+//新程序在期限内未能稳定连接时后端会回滚，因此期限过后不久仍接受回滚的报告
+func updateOnline(name string, info map[string]interface{}) {
+	setProgress(name, func(us *updateStat) {
+		if us.Stat != "restarting" && (us.Stat != "completed" || time.Since(us.Until) > updGrace) {
+			return
+		}
+		us.Ver, _ = info["version"].(string)
+		sum, _ := info["checksum"].(string)
+		switch {
+		case sum != us.Sum:
+			us.Stat = "rollback"
+			us.Mesg = "backend is not running the new program"
+		case time.Now().Before(us.Until):
+			us.Stat = "completed"
+			us.Mesg = ""
+		default:
+			us.Stat = "rollback" //超过期限才重连，后端已回滚到旧版本
+		}
+	})
+}
+
+//startUpdate 开始推送新程序，返回false表示该后端的更新正在进行
+func startUpdate(name, key string, bin []byte, wait int) bool {
+	updates.Lock()
+	if us := updates.m[name]; us != nil && us.Stat == "uploading" {
+		updates.Unlock()
+		return false
+	}
+	sum := sha256.Sum256(bin)
+	updates.m[name] = &updateStat{
+		Sum:   hex.EncodeToString(sum[:]),
+		Size:  int64(len(bin)),
+		Stat:  "uploading",
+		Start: time.Now(),
+	}
+	updates.Unlock()
+	go func() {
+		if err := pushUpdate(name, key, bin, wait); err != nil {
+			base.Log("update(%s): %v", name, err)
+			setProgress(name, func(us *updateStat) {
+				us.Stat = "failed"
+				us.Mesg = err.Error()
+			})
+		}
+	}()
+	return true
+}
+
+func pushUpdate(name, key string, bin []byte, wait int) error {
+	call := func(args map[string]interface{}) (int64, error) {
+		rep, err := callBackend(name, 6, args, chanLife)
+		if err != nil {
+			return 0, err
+		}
+		if rep["stat"] != true {
+			return 0, fmt.Errorf("%v", rep["mesg"])
+		}
+		recv, _ := rep["data"].(map[string]interface{})["recv"].(float64)
+		setProgress(name, func(us *updateStat) { us.Recv = int64(recv) })
+		return int64(recv), nil
+	}
+	sum := sha256.Sum256(bin)
+	_, err := call(map[string]interface{}{
+		"op":     "begin",
+		"size":   len(bin),
+		"sha256": hex.EncodeToString(sum[:]),
+		"sign":   hex.EncodeToString(base.Sign(base.SubKey(key, "update"), sum[:])),
+	})
+	if err != nil {
+		return err
+	}
+	conn, err := backendConn(name)
+	if err != nil {
+		return err
+	}
+	for i, off := 0, 0; off < len(bin); i, off = i+1, off+dataChunk {
+		end := off + dataChunk
+		if end > len(bin) {
+			end = len(bin)
+		}
+		buf := make([]byte, 9, 9+end-off)
+		buf[0] = 7
+		binary.BigEndian.PutUint64(buf[1:], uint64(off))
+		if err := base.Reply(conn, 0, append(buf, bin[off:end]...)); err != nil {
+			return err
+		}
+		setProgress(name, func(us *updateStat) { us.Sent = int64(end) })
+		if i%updSync == updSync-1 {
+			if _, err := call(map[string]interface{}{"op": "sync"}); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = call(map[string]interface{}{"op": "commit", "wait": wait})
+	if err != nil {
+		return err
+	}
+	base.Log("update(%s): %d bytes committed, backend restarting", name, len(bin))
+	setProgress(name, func(us *updateStat) {
+		us.Stat = "restarting"
+		us.Until = time.Now().Add(time.Duration(wait) * time.Second)
+	})
+	return nil
+}
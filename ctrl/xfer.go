@@ -0,0 +1,80 @@
+package ctrl
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const xferKeep = time.Hour //已结束的文件传输记录保留时间
+
+type xferStat struct { //文件传输进度
+	ID    uint32    `json:"id"`
+	Site  string    `json:"site"`
+	Path  string    `json:"path"`
+	Dir   string    `json:"dir"` //up（上传）或down（下载）
+	Size  int64     `json:"size"`
+	Done  int64     `json:"done"` //已完成的字节数（含续传前已有部分）
+	Stat  string    `json:"stat"` //active、done或failed
+	Mesg  string    `json:"mesg,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+var xfers = struct {
+	m    map[uint32]*xferStat
+	next uint32
+	sync.Mutex
+}{m: make(map[uint32]*xferStat)}
+
+func newXfer(site, path, dir string, size, offset int64) *xferStat {
+	xfers.Lock()
+	defer xfers.Unlock()
+	for id, x := range xfers.m {
+		if x.Stat != "active" && time.Since(x.End) > xferKeep {
+			delete(xfers.m, id)
+		}
+	}
+	xfers.next++
+	x := &xferStat{
+		ID:    xfers.next,
+		Site:  site,
+		Path:  path,
+		Dir:   dir,
+		Size:  size,
+		Done:  offset,
+		Stat:  "active",
+		Start: time.Now(),
+	}
+	xfers.m[x.ID] = x
+	return x
+}
+
+func (x *xferStat) progress(done int64) {
+	xfers.Lock()
+	defer xfers.Unlock()
+	x.Done = done
+}
+
+func (x *xferStat) finish(err error) {
+	xfers.Lock()
+	defer xfers.Unlock()
+	x.End = time.Now()
+	if err != nil {
+		x.Stat = "failed"
+		x.Mesg = err.Error()
+	} else {
+		x.Stat = "done"
+	}
+}
+
+func listXfers() []xferStat {
+	xfers.Lock()
+	defer xfers.Unlock()
+	list := []xferStat{}
+	for _, x := range xfers.m {
+		list = append(list, *x)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list
+}
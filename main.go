@@ -24,18 +24,46 @@ func main() {
 	cfg := flag.String("conf", "", "configuration file")
 	init := flag.Bool("init", false, "create sample configuration "+
 		"(without -conf), or\nreset OTP key (with -conf)")
+	audit := flag.Bool("audit", false, "show audit trail of the backend (with -conf)")
+	name := flag.String("name", "", "backend to show audit trail or run COMMAND for,\n"+
+		"when multiple backends are configured")
+	tun := flag.String("tunnel", "", "forward local connections to gateway token port\n"+
+		"(host:port), see -token and -listen")
+	tok := flag.String("token", "", "token for -tunnel (from /dk/conn?token=1), visible to\n"+
+		"other local users via ps, prefer -token-file or DK_TOKEN")
+	tkf := flag.String("token-file", "", "file containing the token for -tunnel (- for stdin)")
+	pin := flag.String("pin", "", "SHA-256 fingerprint of gateway certificate for -tunnel\n"+
+		"(needed for self-signed certificate)")
+	lsn := flag.String("listen", "127.0.0.1:0", "local address for -tunnel")
 	flag.Usage = func() {
 		fmt.Printf("DoorKeeper %s\n\n", verinfo())
-		fmt.Printf("USAGE: %s [OPTIONS]\n\n", filepath.Base(os.Args[0]))
+		fmt.Printf("USAGE: %s [OPTIONS] [COMMAND]\n\n", filepath.Base(os.Args[0]))
 		fmt.Printf("OPTIONS:\n\n")
 		flag.PrintDefaults()
+		fmt.Printf("\nCOMMANDS (control a running backend, with -conf):\n\n")
+		fmt.Printf("  sessions       list sessions\n")
+		fmt.Printf("  status         show connection state with the gateway\n")
+		fmt.Printf("  close <id>     close a session\n")
+		fmt.Printf("  reconnect      reconnect to the gateway\n")
+		fmt.Printf("  pause|resume   stop/restart accepting new sessions\n")
 	}
 	flag.Parse()
 	if *ver {
 		fmt.Println(verinfo())
 		return
 	}
-	if len(flag.Args()) > 0 {
+	if *tun != "" {
+		t, err := tunnelToken(*tok, *tkf)
+		if err == nil {
+			err = tunnel(*tun, t, *pin, *lsn)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(flag.Args()) > 0 && *cfg == "" {
 		fmt.Printf("invalid command line arguments: %s\n\n", strings.Join(flag.Args(), " "))
 		flag.Usage()
 		os.Exit(1)
@@ -53,6 +81,36 @@ func main() {
 		return
 	}
 	loadConfig(*cfg)
+	if *audit {
+		if cf.Mode != "backend" {
+			fmt.Println("audit trail is for DK backend only (given gateway config)")
+			return
+		}
+		b, err := cf.identity(*name)
+		if err == nil {
+			err = serv.ShowAudit(b.Audit, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(flag.Args()) > 0 {
+		if cf.Mode != "backend" {
+			fmt.Println("commands are for DK backend only (given gateway config)")
+			os.Exit(1)
+		}
+		b, err := cf.identity(*name)
+		if err == nil {
+			err = serv.Control(b, flag.Args(), os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if *init {
 		if cf.Mode == "gateway" {
 			nx := regexp.MustCompile(`^\w{1,32}$`)
@@ -88,7 +146,15 @@ func main() {
 	}
 	switch cf.Mode {
 	case "backend":
-		serv.Start(cf.Backend)
+		var cs []*serv.Client
+		for _, b := range append([]serv.Config{cf.Backend}, cf.Backends...) {
+			c := serv.NewClient(b)
+			assert(c.Start())
+			cs = append(cs, c)
+		}
+		for _, c := range cs {
+			<-c.Context().Done()
+		}
 	case "gateway":
 		if len(cf.Gateway.Users) == 0 {
 			fmt.Fprintln(os.Stderr, `ERROR: no user defined (gateway.users), use "-init" to generate`)
@@ -5,18 +5,41 @@ mode:               # 工作模式，必须是"gateway"（控制端）或"backen
 debug: false        # 调试模式（输出更多log信息）
 ulimit: 1024        # 最大句柄数量（一般无需调整）
 gateway:            # 控制端配置
-  mgmt_port: 3535   # 管理端口（HTTP API）
+  mgmt_port: 3535   # 管理端口（HTTPS API）
+  tls_cert: dk.crt  # 管理端口的TLS证书（相对目录基于本配置文件，证书及私钥均不存在时
+  tls_key: dk.key   # 自动生成自签名证书并保存，其指纹在启动时输出）
+  http_port: 0      # 将HTTP请求重定向到HTTPS的端口（0表示不开启）
   serv_port: 35350  # 服务端口（从该端口开始自动分配，第一个用于后端接入，
                     # 后续为用户端接入）
+  token_port: 0     # 共享的令牌接入端口（0表示不开启）：以令牌而非来源IP区分授权，
+                    # 适用于多个用户共享同一公网IP的情形
   web_root: webroot # 管理界面相关资源目录
   max_serves: 9     # 最大接入端数量（最大不得超过99）
   handshake: 10     # 握手时间窗口（秒，最大不得超过60）
   keep_alive: 60    # 保活心跳（秒，设为负值则不发送PING包）
   idle_close: 600   # 空闲工作连接时效（秒，最大不得超过86400，若为0则使用auth_time）
-  auth_time: 3600   # 连接授权最长时限（秒，最大不得超过86400）
+  auth_time: 3600   # 连接授权最长时限（秒，最大不得超过86400），也是登录令牌的有效期
+  audit: gateway-audit.jsonl # 审计记录文件（JSON lines格式，相对目录基于本配置文件，不能放在LOG目录中）
+  audit_size: 16777216 # 审计记录文件超过该字节数则轮转为<audit>.1、<audit>.2……
+  audit_keep: 10    # 保留的已轮转审计记录文件数
+  token_file: tokens.json # 登录令牌的保存文件（相对目录基于本配置文件，只保存令牌的散列值）
   otp_issuer:       # OTP签发机构（仅显示用途，默认为'Door Keeper'）
+  otp_state: otp_state.json # 各用户最后使用的OTP时间步（防止同一OTP被重复使用）
   users:            # 基于OTP的用户访问控制
     #name: otp-key
+  admins: []        # 管理员（须为users中的用户，本机以PID访问者总是管理员）
+  groups:           # 用户组（组名: [用户, ...]）
+    #ops: [name]
+  policies:         # 访问策略（键为用户名或"@组名"；无策略的用户不受限制，有多条策略时任一允许即可）
+    #name:
+    #  sites: [site1]            # 允许访问的后端（为空表示全部）
+    #  hosts: [192.168.1.0/24]   # 允许连接的目标（CIDR或IP，为空表示全部）
+    #  ports: ["22", "8000-8080"] # 允许连接的端口（为空表示全部）
+    #  scan: false               # 是否允许端口扫描（扫描结果只包含允许连接的主机）
+    #  manage: false             # 是否允许远程管理（exec、file、conf、update及cmd，管理员总是允许）
+    #  delegate: [10.8.0.0/16]   # 允许以from参数为其他来源申请授权的网段（为空表示不允许）
+  lock_after: 5     # 同一IP或用户连续登录失败多少次后锁定
+  lock_time: 30     # 首次锁定的时间（秒），此后每次失败加倍（最长1小时）
   auths:            # 通信密钥组（用于客户端认证）
     #name: shared-key
 backend:            # 服务端配置
@@ -26,6 +49,27 @@ backend:            # 服务端配置
   auth:             # 共享密钥
   lan_nets: []      # 本地网络定义（用于端口扫描，CIDR格式的数组）
   scan_ttl: 1000    # 端口扫描时尝试连接的超时时间（毫秒，范围100～5000）
+  remote:           # 允许控制端远程修改的配置项（修改保存在同目录的remote.yaml中，优先于本文件）
+    lan_nets: false
+    scan_ttl: false
+  commands:         # 允许控制端执行的命令（名称: [命令, 参数...]，不经过shell）
+    #ipaddr: [ip, addr]
+  file_root:        # 文件传输的根目录（为空则禁止文件传输，相对目录基于本配置文件）
+  audit: audit.jsonl # 审计记录文件（JSON lines格式，相对目录基于本配置文件，不能放在LOG目录中）
+  control: dk.sock  # 本地控制API的Unix socket（相对目录基于本配置文件）
+  max_sessions: 0   # 最大并发连接数（0表示不限）
+  max_per_dest: 0   # 每个目标的最大并发连接数（0表示不限）
+  max_duration: 0   # 单个连接的最长时间（秒，0表示不限）
+  proxy_protocol:   # 连接目标时发送PROXY协议头，告知真实的客户端地址（目标: v1或v2）
+    #192.168.1.10:22: v2 # 目标可以是"IP:端口"、"IP"或":端口"
+  consent:
+    enable: false   # 新连接是否需经后端用户在本地页面确认
+    listen: 127.0.0.1:3536 # 本地确认页面的监听地址（backends中的第n个身份默认为3536+n，不能重复）
+    timeout: 60     # 等待确认的最长时间（秒），超时视为拒绝
+#backends:           # 同一进程中运行的其他后端身份（配置项同backend）
+#- name: site2
+#  ctrl_host: dk.example.com
+#  auth: secret
 logging:
   path: ../log      # LOG文件目录（相对目录基于本配置文件）
   split: 1048576    # 最大LOG字节数（超过则切分）
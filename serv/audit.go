@@ -0,0 +1,131 @@
+package serv
+
+import (
+	"bufio"
+	"dk/base"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	//session 后端维护的目标连接及其审计信息
+	session struct {
+		*base.Conn
+		id    uint32
+		dest  string
+		addr  *net.TCPAddr //目标地址（为nil表示目标无效）
+		from  *net.TCPAddr //控制端接入的客户端地址（若控制端提供）
+		proxy string       //需发送的PROXY协议版本
+		user  string       //控制端用户（若控制端提供）
+		start time.Time
+		sent  int64 //控制端=>目标的字节数
+		recv  int64 //目标=>控制端的字节数
+	}
+	auditRec struct {
+		Time    time.Time `json:"time"`
+		Event   string    `json:"event"` //open或close
+		Session string    `json:"session"`
+		Dest    string    `json:"dest"`
+		From    string    `json:"from,omitempty"`
+		User    string    `json:"user,omitempty"`
+		Start   time.Time `json:"start"`
+		Sent    int64     `json:"sent"`
+		Recv    int64     `json:"recv"`
+		Reason  string    `json:"reason,omitempty"` //关闭原因
+	}
+)
+
+func newSession(id uint32, dest []byte) *session {
+	s := &session{Conn: base.NewConn(nil), id: id, dest: "invalid", start: time.Now()}
+	s.addr, s.from, s.user = parseDest(dest)
+	if s.addr != nil {
+		s.dest = s.addr.String()
+	}
+	return s
+}
+
+//audit 向审计记录文件（JSON lines格式，只追加）追加一条记录，失败只记录LOG，不影响连接
+func (c *Client) audit(s *session, event, reason string) {
+	file := c.conf().Audit
+	if file == "" {
+		return
+	}
+	ar := auditRec{
+		Time:    time.Now(),
+		Event:   event,
+		Session: fmt.Sprintf("%08x", s.id),
+		Dest:    s.dest,
+		From:    addrString(s.from),
+		User:    s.user,
+		Start:   s.start,
+		Sent:    atomic.LoadInt64(&s.sent),
+		Recv:    atomic.LoadInt64(&s.recv),
+		Reason:  reason,
+	}
+	buf, _ := json.Marshal(ar)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		base.Log("audit: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		base.Log("audit: %v", err)
+	}
+}
+
+func addrString(a *net.TCPAddr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+//closeSession 关闭目标连接并记录审计信息（仅由procPackets调用）
+func (c *Client) closeSession(id uint32, reason string) {
+	c.takeConsent(id)
+	s := c.peer[id]
+	if s == nil {
+		return
+	}
+	s.Close()
+	delete(c.peer, id)
+	c.audit(s, "close", reason)
+}
+
+//ShowAudit 以可读格式输出审计记录，供后端用户在本地查看
+func ShowAudit(fn string, w io.Writer) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	const tf = "2006-01-02 15:04:05"
+	fmt.Fprintf(w, "%-19s  %-5s  %-8s  %-21s  %-12s  %10s  %10s  %8s  %s\n", "TIME",
+		"EVENT", "SESSION", "DESTINATION", "USER", "SENT", "RECEIVED", "DURATION", "REASON")
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var ar auditRec
+		if err := json.Unmarshal(s.Bytes(), &ar); err != nil {
+			continue
+		}
+		user := ar.User
+		if user == "" {
+			user = "-"
+		}
+		if ar.Event == "open" {
+			fmt.Fprintf(w, "%-19s  %-5s  %-8s  %-21s  %s\n", ar.Time.Format(tf),
+				ar.Event, ar.Session, ar.Dest, user)
+			continue
+		}
+		dur := ar.Time.Sub(ar.Start).Truncate(time.Second)
+		fmt.Fprintf(w, "%-19s  %-5s  %-8s  %-21s  %-12s  %10d  %10d  %8s  %s\n", ar.Time.Format(tf),
+			ar.Event, ar.Session, ar.Dest, user, ar.Sent, ar.Recv, dur, ar.Reason)
+	}
+	return s.Err()
+}
@@ -1,32 +1,186 @@
 package serv
 
 import (
+	"context"
 	"dk/base"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func Start(cf Config) {
-	go procPackets(cf)
-	addr := fmt.Sprintf("%s:%d", cf.CtrlHost, cf.CtrlPort)
-	for {
+const minHookCode = 64 //自定义命令代码的最小值，更小的代码保留给DK内置命令
+
+type (
+	//Handler 自定义命令的处理函数，args为控制端发送的参数，返回值以JSON格式回复控制端
+	Handler func(ctx context.Context, args []byte) (interface{}, error)
+	//Client 一个后端身份，维护与控制端的连接及其所有目标连接。同一进程可以运行多个Client
+	Client struct {
+		cf       Config
+		confLock sync.RWMutex //remoteConf会替换cf，其他线程须经conf读取
+		ctx      context.Context
+		stop     context.CancelFunc
+		master   net.Conn
+		peer     map[uint32]*session //维护所有目标连接，索引为SESSION-ID（仅由procPackets访问）
+		ch       chan packet
+		hooks    map[byte]Handler
+		paused   int32 //为1表示暂停接受新连接
+		online   int64 //与控制端连接建立的时间（UNIX纳秒），为0表示未连接
+		updating int32 //为1表示正在提交自我更新
+		upd      *updateJob
+		uploads  map[uint32]*upload //进行中的上传，索引为句柄
+		sink     struct {           //接收控制端发送的测试数据（ChunkCMD#13）
+			bytes int64
+			start time.Time
+		}
+		consents struct {
+			m     map[uint32]*consentReq //等待本地用户确认的连接请求
+			nonce map[string]time.Time   //确认页面每次显示时生成的一次性随机数及其失效时间
+			sync.Mutex
+		}
+		sync.Mutex
+	}
+)
+
+var clients = struct { //本进程中运行的所有Client
+	m map[*Client]bool
+	sync.Mutex
+}{m: make(map[*Client]bool)}
+
+//NewClient 创建后端，未设置的配置项使用默认值
+func NewClient(cf Config) *Client {
+	cf.defaults()
+	c := &Client{
+		cf:      cf,
+		peer:    make(map[uint32]*session),
+		ch:      make(chan packet, queueCap),
+		hooks:   make(map[byte]Handler),
+		uploads: make(map[uint32]*upload),
+	}
+	c.consents.m = make(map[uint32]*consentReq)
+	c.consents.nonce = make(map[string]time.Time)
+	c.ctx, c.stop = context.WithCancel(context.Background())
+	return c
+}
+
+//conf 返回当前配置的副本
+func (c *Client) conf() Config {
+	c.confLock.RLock()
+	defer c.confLock.RUnlock()
+	return c.cf
+}
+
+func (c *Client) setConf(cf Config) {
+	c.confLock.Lock()
+	c.cf = cf
+	c.confLock.Unlock()
+}
+
+//Name 返回后端名称
+func (c *Client) Name() string {
+	return c.conf().Name
+}
+
+//Context 返回后端的上下文，Stop后被取消
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+//Handle 注册自定义命令，code须不小于64，须在Start之前调用
+func (c *Client) Handle(code byte, h Handler) error {
+	if code < minHookCode {
+		return fmt.Errorf("command code %d is reserved", code)
+	}
+	c.hooks[code] = h
+	return nil
+}
+
+//Start 开始连接控制端（不阻塞），断线后自动重连，直到Stop
+func (c *Client) Start() error {
+	clients.Lock()
+	defer clients.Unlock()
+	if clients.m[c] {
+		return errors.New("client already started")
+	}
+	if c.ctx.Err() != nil {
+		return errors.New("client stopped")
+	}
+	clients.m[c] = true
+	cf := c.conf()
+	if cf.SelfUpdate {
+		watchUpdate()
+	}
+	if cf.Consent.Enable {
+		c.startConsentPage()
+	}
+	c.startControl()
+	go c.expireSessions()
+	if cf.FileRoot != "" {
+		go c.expireUploads()
+	}
+	go c.procPackets()
+	go c.run()
+	return nil
+}
+
+//Stop 断开与控制端的连接，关闭所有目标连接
+func (c *Client) Stop() {
+	c.stop()
+	c.Lock()
+	if c.master != nil {
+		c.master.Close()
+	}
+	c.Unlock()
+	clients.Lock()
+	delete(clients.m, c)
+	clients.Unlock()
+}
+
+//masterConn 返回当前的主控连接（未连接时为nil或已关闭的连接）
+func (c *Client) masterConn() net.Conn {
+	c.Lock()
+	defer c.Unlock()
+	return c.master
+}
+
+func (c *Client) run() {
+	cf := c.conf()
+	addr := net.JoinHostPort(cf.CtrlHost, strconv.Itoa(cf.CtrlPort))
+	for c.ctx.Err() == nil {
 		func() {
 			d := net.Dialer{Timeout: time.Duration(cf.ConnWait) * time.Second}
-			conn, err := d.Dial("tcp", addr)
+			conn, err := d.DialContext(c.ctx, "tcp", addr)
 			if err != nil {
 				base.Log("%v", err)
 				return
 			}
-			base.Log("connected to %s", addr)
+			base.Log("[%s] connected to %s", cf.Name, addr)
 			handshake := base.Authenticate(nil, cf.Name, cf.Auth)
 			_, err = conn.Write(handshake)
 			if err != nil {
 				base.Log("%v", err)
 				return
 			}
-			serve(conn, cf)
+			c.serve(conn)
 		}()
-		time.Sleep(time.Second)
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+//isOnline 本进程中是否有后端与控制端保持连接超过d
+func isOnline(d time.Duration) bool {
+	clients.Lock()
+	defer clients.Unlock()
+	for c := range clients.m {
+		if t := atomic.LoadInt64(&c.online); t > 0 && time.Since(time.Unix(0, t)) > d {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,47 @@
+package serv
+
+import (
+	"dk/base"
+	"encoding/json"
+	"fmt"
+)
+
+//respond 以JSON格式回复控制端的命令（命令代码>=3）
+func (c *Client) respond(session uint32, code byte, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		buf, _ = json.Marshal(map[string]interface{}{
+			"stat": false,
+			"mesg": err.Error(),
+		})
+	}
+	if err := base.Respond(c.masterConn(), session, code, buf); err != nil {
+		base.Log("respond(cmd#%d): %v", code, err)
+	}
+}
+
+//hook 执行自定义命令（命令代码>=64）
+func (c *Client) hook(session uint32, code byte, args []byte) {
+	h := c.hooks[code]
+	if h == nil {
+		c.respond(session, code, failure("unknown command %d", code))
+		return
+	}
+	data, err := h(c.ctx, args)
+	if err != nil {
+		c.respond(session, code, failure("%v", err))
+		return
+	}
+	c.respond(session, code, success(data))
+}
+
+func failure(format string, args ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"stat": false,
+		"mesg": fmt.Sprintf(format, args...),
+	}
+}
+
+func success(data interface{}) map[string]interface{} {
+	return map[string]interface{}{"stat": true, "data": data}
+}
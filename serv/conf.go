@@ -1,11 +1,57 @@
 package serv
 
 type Config struct {
-	Name     string   `yaml:"name"`
-	ConnWait int      `yaml:"conn_wait"`
-	CtrlHost string   `yaml:"ctrl_host"`
-	CtrlPort int      `yaml:"ctrl_port"`
-	Auth     string   `yaml:"auth"`
-	LanNets  []string `yaml:"lan_nets"`
-	ScanTTL  int      `yaml:"scan_ttl"`
+	Name     string              `yaml:"name"`
+	ConnWait int                 `yaml:"conn_wait"`
+	CtrlHost string              `yaml:"ctrl_host"`
+	CtrlPort int                 `yaml:"ctrl_port"`
+	Auth     string              `yaml:"auth"`
+	LanNets  []string            `yaml:"lan_nets"`
+	ScanTTL  int                 `yaml:"scan_ttl"`
+	Remote   map[string]bool     `yaml:"remote"`         //允许控制端远程修改的配置项
+	Commands map[string][]string `yaml:"commands"`       //允许控制端执行的命令（名称=>命令及参数）
+	Consent  ConsentConf         `yaml:"consent"`        //本地用户确认
+	FileRoot string              `yaml:"file_root"`      //文件传输的根目录（为空表示禁止文件传输）
+	Audit    string              `yaml:"audit"`          //审计记录文件
+	Control  string              `yaml:"control"`        //本地控制API的Unix socket
+	Proxy    map[string]string   `yaml:"proxy_protocol"` //向目标发送PROXY协议头（目标=>v1或v2）
+	MaxSess  int                 `yaml:"max_sessions"`   //最大并发连接数（0表示不限）
+	MaxDest  int                 `yaml:"max_per_dest"`   //每个目标的最大并发连接数（0表示不限）
+	MaxTime  int                 `yaml:"max_duration"`   //单个连接的最长时间（秒，0表示不限）
+	Version  string              `yaml:"-"`
+	Persist  func(Config) error  `yaml:"-"` //保存远程修改的配置（下次启动时仍然有效）
+	ConfTime int64               `yaml:"-"` //最后接受的远程配置的时间戳（防止重放，经Persist保存）
+	//SelfUpdate 允许控制端替换本进程的可执行文件（仅适用于dk程序本身，嵌入其他程序时不应设置）
+	SelfUpdate bool `yaml:"-"`
+}
+
+//defaults 为未设置或无效的配置项设置默认值
+func (cf *Config) defaults() {
+	if cf.CtrlPort <= 0 || cf.CtrlPort > 65535 {
+		cf.CtrlPort = 35350
+	}
+	if cf.ConnWait <= 0 || cf.ConnWait > 300 {
+		cf.ConnWait = 60
+	}
+	if cf.ScanTTL < 100 {
+		cf.ScanTTL = 1000
+	}
+	if cf.ScanTTL > 5000 {
+		cf.ScanTTL = 5000
+	}
+	if cf.Consent.Listen == "" {
+		cf.Consent.Listen = "127.0.0.1:3536"
+	}
+	if cf.Consent.Timeout <= 0 || cf.Consent.Timeout > 600 {
+		cf.Consent.Timeout = 60
+	}
+	if cf.MaxSess < 0 {
+		cf.MaxSess = 0
+	}
+	if cf.MaxDest < 0 {
+		cf.MaxDest = 0
+	}
+	if cf.MaxTime < 0 {
+		cf.MaxTime = 0
+	}
 }
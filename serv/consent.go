@@ -0,0 +1,224 @@
+package serv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"dk/base"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	//chunkACK 本地用户对连接请求的决定（内部使用），包体为SESSION-ID及1字节决定（1为同意）
+	chunkACK  base.ChunkType = 5
+	nonceLife                = 10 * time.Minute //确认页面随机数的有效时间
+)
+
+type (
+	ConsentConf struct {
+		Enable  bool   `yaml:"enable"`  //新连接需经本地用户同意
+		Listen  string `yaml:"listen"`  //本地确认页面的监听地址
+		Timeout int    `yaml:"timeout"` //等待确认的最长时间（秒），超时视为拒绝
+	}
+	consentReq struct {
+		Session uint32    `json:"session"`
+		Dest    string    `json:"dest"`
+		From    string    `json:"from"` //客户端地址（若控制端提供）
+		User    string    `json:"user"` //控制端用户（若控制端提供）
+		Since   time.Time `json:"since"`
+	}
+)
+
+//notifyConsent 通知控制端连接请求的确认状态（waiting、approved或denied）
+func (c *Client) notifyConsent(session uint32, stat, dest string) {
+	var msg bytes.Buffer
+	msg.WriteByte(14)
+	json.NewEncoder(&msg).Encode(map[string]interface{}{
+		"session": session,
+		"stat":    stat,
+		"dest":    dest,
+	})
+	if err := base.Reply(c.masterConn(), 0, msg.Bytes()); err != nil {
+		base.Log("notifyConsent: %v", err)
+	}
+}
+
+//askConsent 登记连接请求，等待本地用户确认，超时则拒绝
+func (c *Client) askConsent(s *session) {
+	session := s.id
+	cr := &consentReq{Session: session, Dest: s.dest, From: addrString(s.from), User: s.user, Since: time.Now()}
+	c.consents.Lock()
+	c.consents.m[session] = cr
+	c.consents.Unlock()
+	base.Log("session %x => %s: waiting for consent", session, cr.Dest)
+	c.notifyConsent(session, "waiting", cr.Dest)
+	go func(timeout time.Duration) {
+		time.Sleep(timeout)
+		c.decide(session, false)
+	}(time.Duration(c.conf().Consent.Timeout) * time.Second)
+}
+
+//decide 将本地用户的决定交给procPackets处理
+func (c *Client) decide(session uint32, approve bool) {
+	buf := make([]byte, 5)
+	binary.BigEndian.PutUint32(buf, session)
+	if approve {
+		buf[4] = 1
+	}
+	c.post(packet{ct: chunkACK, buf: buf})
+}
+
+//takeConsent 取出等待确认的连接请求，若不存在（已处理或已关闭）则返回nil
+func (c *Client) takeConsent(session uint32) *consentReq {
+	c.consents.Lock()
+	defer c.consents.Unlock()
+	cr := c.consents.m[session]
+	delete(c.consents.m, session)
+	return cr
+}
+
+func (c *Client) pendingConsent(session uint32) bool {
+	c.consents.Lock()
+	defer c.consents.Unlock()
+	return c.consents.m[session] != nil
+}
+
+//procConsent 由procPackets调用，处理本地用户的决定
+func (c *Client) procConsent(session uint32, approve bool) {
+	cr := c.takeConsent(session)
+	if cr == nil || c.peer[session] == nil {
+		return
+	}
+	if approve {
+		base.Log("session %x => %s: approved", session, cr.Dest)
+		c.notifyConsent(session, "approved", cr.Dest)
+		go c.openSession(c.peer[session])
+		return
+	}
+	base.Log("session %x => %s: denied", session, cr.Dest)
+	c.notifyConsent(session, "denied", cr.Dest)
+	c.closeSession(session, "denied by local user")
+	base.Close(c.masterConn(), session) //向控制端通告该后端连接关闭
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="5">
+<title>DoorKeeper</title></head><body>
+<h3>远程访问请求（{{.Name}}）</h3>
+{{with .List}}<table border="1" cellpadding="6" style="border-collapse:collapse">
+<tr><th>目标</th><th>来源</th><th>用户</th><th>请求时间</th><th></th></tr>
+{{range .}}<tr><td>{{.Dest}}</td><td>{{or .From "-"}}</td><td>{{or .User "-"}}</td><td>{{.Since.Format "2006-01-02 15:04:05"}}</td><td>
+<form method="post" style="display:inline"><input type="hidden" name="id" value="{{.Session}}">
+<input type="hidden" name="nonce" value="{{$.Nonce}}">
+<button name="act" value="approve">同意</button> <button name="act" value="deny">拒绝</button></form>
+</td></tr>{{end}}</table>{{else}}<p>当前没有等待确认的请求</p>{{end}}
+</body></html>`))
+
+func (c *Client) pendingConsents() []consentReq {
+	c.consents.Lock()
+	defer c.consents.Unlock()
+	list := []consentReq{}
+	for _, cr := range c.consents.m {
+		list = append(list, *cr)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+//newNonce 生成确认页面的一次性随机数（同时清理已失效的随机数）
+func (c *Client) newNonce() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	nonce := hex.EncodeToString(buf)
+	now := time.Now()
+	c.consents.Lock()
+	defer c.consents.Unlock()
+	for n, t := range c.consents.nonce {
+		if now.After(t) {
+			delete(c.consents.nonce, n)
+		}
+	}
+	c.consents.nonce[nonce] = now.Add(nonceLife)
+	return nonce
+}
+
+//useNonce 校验并作废随机数
+func (c *Client) useNonce(nonce string) bool {
+	c.consents.Lock()
+	defer c.consents.Unlock()
+	t, ok := c.consents.nonce[nonce]
+	delete(c.consents.nonce, nonce)
+	return ok && time.Now().Before(t)
+}
+
+//localHost 请求的Host是否为确认页面的监听地址（本机地址或localhost），以防DNS重绑定
+func (c *Client) localHost(r *http.Request) bool {
+	_, lp, err := net.SplitHostPort(c.conf().Consent.Listen)
+	if err != nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || port != lp {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+//startConsentPage 启动本地确认页面（仅应监听本机地址），后端停止时关闭。
+//确认须携带页面中的一次性随机数，并拒绝Host不是本机地址或来自其他站点的请求，
+//以防其他网页伪造请求
+func (c *Client) startConsentPage() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !c.localHost(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method == "POST" {
+			if o := r.Header.Get("Origin"); o != "" && o != "http://"+r.Host {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !c.useNonce(r.FormValue("nonce")) {
+				http.Error(w, "invalid or expired page, please reload", http.StatusForbidden)
+				return
+			}
+			id, _ := strconv.ParseUint(r.FormValue("id"), 10, 32)
+			c.decide(uint32(id), r.FormValue("act") == "approve")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Add("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		consentPage.Execute(w, map[string]interface{}{
+			"Name":  c.conf().Name,
+			"List":  c.pendingConsents(),
+			"Nonce": c.newNonce(),
+		})
+	})
+	svr := http.Server{Addr: c.conf().Consent.Listen, Handler: mux}
+	go func() {
+		<-c.ctx.Done()
+		svr.Close()
+	}()
+	go func() {
+		base.Log("consent page: http://%s/", svr.Addr)
+		if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			base.Log("consent page: %v", err)
+		}
+	}()
+}
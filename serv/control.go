@@ -0,0 +1,244 @@
+package serv
+
+import (
+	"context"
+	"dk/base"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//call 在procPackets线程内执行f并等待其返回，以免与包处理冲突
+func (c *Client) call(f func() interface{}) interface{} {
+	rply := make(chan interface{}, 1)
+	c.post(packet{ct: chunkCTL, call: func() { rply <- f() }})
+	select {
+	case r := <-rply:
+		return r
+	case <-c.ctx.Done():
+		return failure("client stopped")
+	}
+}
+
+func (c *Client) listSessions() interface{} {
+	list := []map[string]interface{}{}
+	for id, s := range c.peer {
+		stat := "connecting"
+		if s.Remote() != nil {
+			stat = "connected"
+		} else if c.pendingConsent(id) {
+			stat = "consent"
+		}
+		list = append(list, map[string]interface{}{
+			"session": fmt.Sprintf("%08x", id),
+			"dest":    s.dest,
+			"user":    s.user,
+			"start":   s.start,
+			"sent":    atomic.LoadInt64(&s.sent),
+			"recv":    atomic.LoadInt64(&s.recv),
+			"stat":    stat,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["start"].(time.Time).Before(list[j]["start"].(time.Time))
+	})
+	return success(list)
+}
+
+func (c *Client) ctrlStatus() interface{} {
+	cf := c.conf()
+	stat := map[string]interface{}{
+		"name":     cf.Name,
+		"version":  cf.Version,
+		"gateway":  net.JoinHostPort(cf.CtrlHost, strconv.Itoa(cf.CtrlPort)),
+		"uptime":   int(time.Since(started).Seconds()),
+		"paused":   atomic.LoadInt32(&c.paused) == 1,
+		"sessions": len(c.peer),
+	}
+	if t := atomic.LoadInt64(&c.online); t > 0 {
+		stat["online"] = time.Unix(0, t)
+	}
+	return success(stat)
+}
+
+func (c *Client) ctrlClose(arg string) interface{} {
+	id, err := strconv.ParseUint(arg, 16, 32)
+	if err != nil {
+		return failure("invalid session: %s", arg)
+	}
+	if c.peer[uint32(id)] == nil {
+		return failure("session %08x not found", id)
+	}
+	c.closeSession(uint32(id), "closed by local user")
+	base.Close(c.masterConn(), uint32(id)) //向控制端通告该后端连接关闭
+	base.Log("session %08x closed by local user", id)
+	return success(nil)
+}
+
+func (c *Client) ctrlReconnect() interface{} {
+	if atomic.LoadInt64(&c.online) == 0 {
+		return failure("not connected to gateway")
+	}
+	base.Log("reconnect requested by local user")
+	c.masterConn().Close() //serve()随之退出，run()会重新连接
+	return success(nil)
+}
+
+//startControl 在Unix socket上提供本地控制API（仅本机用户可访问）
+func (c *Client) startControl() {
+	cf := c.conf()
+	if cf.Control == "" {
+		return
+	}
+	if conn, err := net.Dial("unix", cf.Control); err == nil {
+		conn.Close()
+		base.Log("control: %s is in use", cf.Control)
+		return
+	}
+	os.Remove(cf.Control) //清除上次运行遗留的socket文件
+	ln, err := net.Listen("unix", cf.Control)
+	if err != nil {
+		base.Log("control: %v", err)
+		return
+	}
+	os.Chmod(cf.Control, 0600)
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, c.call(c.listSessions))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, c.call(c.ctrlStatus))
+	})
+	mux.HandleFunc("/close", func(w http.ResponseWriter, r *http.Request) {
+		arg := r.URL.Query().Get("session")
+		reply(w, c.call(func() interface{} { return c.ctrlClose(arg) }))
+	})
+	mux.HandleFunc("/reconnect", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, c.call(c.ctrlReconnect))
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&c.paused, 1)
+		base.Log("new sessions paused by local user")
+		reply(w, success(nil))
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&c.paused, 0)
+		base.Log("new sessions resumed by local user")
+		reply(w, success(nil))
+	})
+	go func() {
+		<-c.ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		base.Log("control socket: %s", cf.Control)
+		if err := http.Serve(ln, mux); err != nil && c.ctx.Err() == nil {
+			base.Log("control: %v", err)
+		}
+	}()
+}
+
+//Control 通过本地控制API操作运行中的后端，args为子命令及其参数
+func Control(cf Config, args []string, w io.Writer) error {
+	path := "/" + args[0]
+	switch args[0] {
+	case "sessions", "status", "reconnect", "pause", "resume":
+		if len(args) != 1 {
+			return fmt.Errorf("%s: no argument expected", args[0])
+		}
+	case "close":
+		if len(args) != 2 {
+			return errors.New("close: session ID expected")
+		}
+		path += "?session=" + args[1]
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	hc := http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", cf.Control)
+			},
+		},
+	}
+	resp, err := hc.Get("http://dk" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var rep struct {
+		Stat bool            `json:"stat"`
+		Mesg string          `json:"mesg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		return err
+	}
+	if !rep.Stat {
+		return errors.New(rep.Mesg)
+	}
+	switch args[0] {
+	case "sessions":
+		var list []struct {
+			Session string    `json:"session"`
+			Dest    string    `json:"dest"`
+			User    string    `json:"user"`
+			Start   time.Time `json:"start"`
+			Sent    int64     `json:"sent"`
+			Recv    int64     `json:"recv"`
+			Stat    string    `json:"stat"`
+		}
+		if err := json.Unmarshal(rep.Data, &list); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%-8s  %-21s  %-12s  %-10s  %-19s  %10s  %10s\n", "SESSION",
+			"DESTINATION", "USER", "STATE", "START", "SENT", "RECEIVED")
+		for _, s := range list {
+			if s.User == "" {
+				s.User = "-"
+			}
+			fmt.Fprintf(w, "%-8s  %-21s  %-12s  %-10s  %-19s  %10d  %10d\n", s.Session, s.Dest,
+				s.User, s.Stat, s.Start.Format("2006-01-02 15:04:05"), s.Sent, s.Recv)
+		}
+	case "status":
+		var st struct {
+			Name     string    `json:"name"`
+			Version  string    `json:"version"`
+			Gateway  string    `json:"gateway"`
+			Uptime   int       `json:"uptime"`
+			Online   time.Time `json:"online"`
+			Paused   bool      `json:"paused"`
+			Sessions int       `json:"sessions"`
+		}
+		if err := json.Unmarshal(rep.Data, &st); err != nil {
+			return err
+		}
+		conn := "disconnected"
+		if !st.Online.IsZero() {
+			conn = "connected since " + st.Online.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "name:     %s\n", st.Name)
+		fmt.Fprintf(w, "version:  %s\n", st.Version)
+		fmt.Fprintf(w, "uptime:   %s\n", time.Duration(st.Uptime)*time.Second)
+		fmt.Fprintf(w, "gateway:  %s (%s)\n", st.Gateway, conn)
+		fmt.Fprintf(w, "sessions: %d\n", st.Sessions)
+		fmt.Fprintf(w, "paused:   %v\n", st.Paused)
+	default:
+		fmt.Fprintln(w, "OK")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package serv
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type diagReq struct {
+	Type    string `json:"type"`    //诊断类型：tcp、dns、trace或netinfo
+	Host    string `json:"host"`    //目标主机（tcp、trace）或域名（dns）
+	Port    int    `json:"port"`    //目标端口（tcp、trace）
+	MaxTTL  int    `json:"max_ttl"` //最大跳数（trace）
+	Timeout int    `json:"timeout"` //超时（毫秒）
+}
+
+//tcpProbe 尝试建立TCP连接并计时，ttl大于0时设置IP包的TTL
+func tcpProbe(addr string, ttl int, timeout time.Duration) (time.Duration, error) {
+	d := net.Dialer{Timeout: timeout}
+	if ttl > 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			ce := c.Control(func(fd uintptr) {
+				err = setTTL(fd, network == "tcp6", ttl)
+			})
+			if ce != nil {
+				return ce
+			}
+			return err
+		}
+	}
+	start := time.Now()
+	conn, err := d.Dial("tcp", addr)
+	elapsed := time.Since(start)
+	if err == nil {
+		conn.Close()
+	}
+	return elapsed, err
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func diagnose(args []byte) map[string]interface{} {
+	var dr diagReq
+	if err := json.Unmarshal(args, &dr); err != nil {
+		return failure("diagnose: %v", err)
+	}
+	if dr.Timeout <= 0 || dr.Timeout > 5000 {
+		dr.Timeout = 3000
+	}
+	timeout := time.Duration(dr.Timeout) * time.Millisecond
+	addr := net.JoinHostPort(dr.Host, strconv.Itoa(dr.Port))
+	switch dr.Type {
+	case "tcp":
+		elapsed, err := tcpProbe(addr, 0, timeout)
+		res := map[string]interface{}{"addr": addr, "time": millis(elapsed)}
+		if err != nil {
+			res["error"] = err.Error()
+		}
+		return map[string]interface{}{"stat": err == nil, "data": res}
+	case "dns":
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, dr.Host)
+		res := map[string]interface{}{
+			"name": dr.Host,
+			"time": millis(time.Since(start)),
+		}
+		if err != nil {
+			res["error"] = err.Error()
+		} else {
+			res["addrs"] = addrs
+		}
+		if cname, err := net.DefaultResolver.LookupCNAME(ctx, dr.Host); err == nil {
+			res["cname"] = cname
+		}
+		return map[string]interface{}{"stat": err == nil, "data": res}
+	case "trace":
+		//不依赖ICMP（无需特权）：以递增的TTL并发发起TCP连接，最先到达
+		//目标的TTL即为跳数，目标返回RST（连接被拒绝）同样视为到达。
+		//只能得到跳数：中间路由器返回的ICMP超时不会交给TCP套接字，
+		//因此跳数以内的各探测只标记为expired，不含路由器地址
+		if dr.MaxTTL <= 0 || dr.MaxTTL > 32 {
+			dr.MaxTTL = 16
+		}
+		probes := make([]map[string]interface{}, dr.MaxTTL)
+		var wg sync.WaitGroup
+		for i := range probes {
+			wg.Add(1)
+			go func(ttl int) {
+				defer wg.Done()
+				elapsed, err := tcpProbe(addr, ttl, timeout)
+				probe := map[string]interface{}{"ttl": ttl, "time": millis(elapsed)}
+				switch {
+				case err == nil:
+					probe["stat"] = "open"
+				case isRefused(err):
+					probe["stat"] = "refused"
+				default:
+					probe["stat"] = "failed"
+					probe["error"] = err.Error()
+				}
+				probes[ttl-1] = probe
+			}(i + 1)
+		}
+		wg.Wait()
+		count := 0
+		for _, p := range probes {
+			if p["stat"] != "failed" {
+				count = p["ttl"].(int)
+				break
+			}
+		}
+		res := map[string]interface{}{"addr": addr, "probes": probes}
+		if count > 0 {
+			probes = probes[:count]
+			for _, p := range probes[:count-1] {
+				p["stat"] = "expired"
+			}
+			res["hop_count"] = count
+			res["probes"] = probes
+		}
+		return map[string]interface{}{"stat": count > 0, "data": res}
+	case "netinfo":
+		return success(netInfo())
+	}
+	return failure("diagnose: unknown type '%s'", dr.Type)
+}
+
+func interfaces() []map[string]interface{} {
+	list := []map[string]interface{}{}
+	ifs, _ := net.Interfaces()
+	for _, i := range ifs {
+		addrs := []string{}
+		as, _ := i.Addrs()
+		for _, a := range as {
+			addrs = append(addrs, a.String())
+		}
+		list = append(list, map[string]interface{}{
+			"name":  i.Name,
+			"mac":   i.HardwareAddr.String(),
+			"mtu":   i.MTU,
+			"flags": i.Flags.String(),
+			"addrs": addrs,
+		})
+	}
+	return list
+}
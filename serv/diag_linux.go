@@ -0,0 +1,107 @@
+//+build linux
+
+package serv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+//procTable 读取/proc下的表格文件（跳过表头），返回每行的字段
+func procTable(fn string, header bool) (rows [][]string) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		if fs := strings.Fields(s.Text()); len(fs) > 0 {
+			rows = append(rows, fs)
+		}
+	}
+	return
+}
+
+func hexIPv4(s string) string {
+	v, _ := strconv.ParseUint(s, 16, 32)
+	ip := make(net.IP, 4)
+	binary.LittleEndian.PutUint32(ip, uint32(v))
+	return ip.String()
+}
+
+func hexIPv6(s string) string {
+	buf, err := hex.DecodeString(s)
+	if err != nil || len(buf) != net.IPv6len {
+		return s
+	}
+	return net.IP(buf).String()
+}
+
+func netInfo() map[string]interface{} {
+	routes := []map[string]interface{}{}
+	for _, r := range procTable("/proc/net/route", true) {
+		if len(r) < 8 {
+			continue
+		}
+		mask := net.ParseIP(hexIPv4(r[7])).To4()
+		ones, _ := net.IPMask(mask).Size()
+		routes = append(routes, map[string]interface{}{
+			"dest":   hexIPv4(r[1]) + "/" + strconv.Itoa(ones),
+			"gw":     hexIPv4(r[2]),
+			"dev":    r[0],
+			"metric": r[6],
+		})
+	}
+	for _, r := range procTable("/proc/net/ipv6_route", false) {
+		if len(r) < 10 {
+			continue
+		}
+		plen, _ := strconv.ParseUint(r[1], 16, 8)
+		routes = append(routes, map[string]interface{}{
+			"dest":   hexIPv6(r[0]) + "/" + strconv.Itoa(int(plen)),
+			"gw":     hexIPv6(r[4]),
+			"dev":    r[9],
+			"metric": r[5],
+		})
+	}
+	neigh := []map[string]interface{}{}
+	for _, r := range procTable("/proc/net/arp", true) {
+		if len(r) < 6 {
+			continue
+		}
+		neigh = append(neigh, map[string]interface{}{
+			"addr":  r[0],
+			"mac":   r[3],
+			"flags": r[2],
+			"dev":   r[5],
+		})
+	}
+	return map[string]interface{}{
+		"interfaces": interfaces(),
+		"routes":     routes,
+		"neighbors":  neigh,
+	}
+}
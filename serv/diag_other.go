@@ -0,0 +1,24 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package serv
+
+import "errors"
+
+var errNotSupported = errors.New("not supported on this platform")
+
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	return errNotSupported
+}
+
+func isRefused(err error) bool {
+	return false
+}
+
+func netInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"interfaces": interfaces(),
+		"routes":     []string{errNotSupported.Error()},
+		"neighbors":  []string{errNotSupported.Error()},
+	}
+}
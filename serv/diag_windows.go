@@ -0,0 +1,45 @@
+//+build windows
+
+package serv
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+func isRefused(err error) bool {
+	const WSAECONNREFUSED = 10061
+	return errors.Is(err, syscall.Errno(WSAECONNREFUSED))
+}
+
+//command 执行系统命令，返回其输出的各行（Windows下路由及邻居表没有文件接口）
+func command(name string, args ...string) []string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var lines []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimRight(l, "\r "); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func netInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"interfaces": interfaces(),
+		"routes":     command("route", "print"),
+		"neighbors":  command("arp", "-a"),
+	}
+}
@@ -0,0 +1,141 @@
+package serv
+
+import (
+	"context"
+	"dk/base"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxOutput  = 1024 * 1024 //命令输出的最大字节数，超出部分丢弃
+	execBlock  = 1024        //每次发送的输出字节数（JSON编码后须小于MTU）
+	maxExecTTL = 300         //命令执行的最长时间（秒）
+)
+
+type execReq struct {
+	Name    string `json:"name"`    //命令名称，为空表示列出所有命令
+	Timeout int    `json:"timeout"` //超时（秒）
+}
+
+//emit 发送流式回复的一个分片（非最后一片），v编码后须小于MTU
+func (c *Client) emit(session uint32, code byte, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return base.Reply(c.masterConn(), session, append([]byte{code, 0}, buf...))
+}
+
+//utf8Cut 返回buf中完整UTF-8字符的长度（末尾可能有被截断的多字节字符）
+func utf8Cut(buf []byte) int {
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if utf8.FullRune(buf[i:]) {
+				return len(buf)
+			}
+			return i
+		}
+	}
+	return len(buf)
+}
+
+//execute 执行白名单（backend.commands）中名为name的命令，以流式回复
+//返回其标准输出和标准错误，最后返回退出码
+func (c *Client) execute(session uint32, cf Config, args []byte) map[string]interface{} {
+	var er execReq
+	if err := json.Unmarshal(args, &er); err != nil {
+		return failure("execute: %v", err)
+	}
+	if er.Name == "" {
+		cmds := []map[string]interface{}{}
+		for n, argv := range cf.Commands {
+			cmds = append(cmds, map[string]interface{}{
+				"name": n,
+				"args": strings.Join(argv, " "),
+			})
+		}
+		sort.Slice(cmds, func(i, j int) bool {
+			return cmds[i]["name"].(string) < cmds[j]["name"].(string)
+		})
+		return success(cmds)
+	}
+	argv := cf.Commands[er.Name]
+	if len(argv) == 0 {
+		return failure("execute: command '%s' not allowed", er.Name)
+	}
+	if er.Timeout <= 0 || er.Timeout > maxExecTTL {
+		er.Timeout = 30
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, time.Duration(er.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return failure("execute: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return failure("execute: %v", err)
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return failure("execute: %v", err)
+	}
+	base.Log("execute[%x]: %s (%s)", session, er.Name, strings.Join(argv, " "))
+	var (
+		mu    sync.Mutex
+		total int
+		wg    sync.WaitGroup
+	)
+	pipe := func(r io.Reader, key string) {
+		defer wg.Done()
+		buf := make([]byte, execBlock)
+		var held int //上次读取末尾被截断的字节数
+		for {
+			n, err := r.Read(buf[held:])
+			n += held
+			if n > 0 {
+				cut := utf8Cut(buf[:n])
+				if err != nil {
+					cut = n
+				}
+				mu.Lock()
+				if total < maxOutput {
+					if e := c.emit(session, 9, map[string]string{key: string(buf[:cut])}); e != nil {
+						base.Log("execute[%x]: %v", session, e)
+					}
+				}
+				total += cut
+				mu.Unlock()
+				held = copy(buf, buf[cut:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go pipe(stdout, "stdout")
+	go pipe(stderr, "stderr")
+	wg.Wait()
+	err = cmd.Wait()
+	res := map[string]interface{}{
+		"exit":      cmd.ProcessState.ExitCode(),
+		"time":      time.Since(start).Seconds(),
+		"truncated": total > maxOutput,
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return map[string]interface{}{"stat": false, "mesg": "timeout", "data": res}
+	}
+	if err != nil {
+		return map[string]interface{}{"stat": false, "mesg": err.Error(), "data": res}
+	}
+	return success(res)
+}
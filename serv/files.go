@@ -0,0 +1,230 @@
+package serv
+
+import (
+	"dk/base"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const uploadIdle = 10 * time.Minute //上传句柄的最长空闲时间
+
+type (
+	fileReq struct {
+		Op     string `json:"op"`     //list、stat、get、open、sync或close
+		Path   string `json:"path"`   //相对于backend.file_root的路径
+		Offset int64  `json:"offset"` //读取（get）或写入（open）的起始位置
+		Handle uint32 `json:"handle"` //上传句柄（open、sync、close），由控制端分配
+	}
+	upload struct {
+		file *os.File
+		name string
+		size int64
+		seen time.Time //最后一次收到数据或操作的时间
+	}
+)
+
+//sandbox 将相对路径p映射到root目录下，确保不会越出root（包括经由符号链接）
+func sandbox(root, p string) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("file transfer disabled")
+	}
+	fn := filepath.Join(root, filepath.FromSlash(path.Clean("/"+p)))
+	dir := fn
+	for { //找到已存在的最深一级目录，检查其真实路径
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	rr, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if real != rr && !strings.HasPrefix(real, rr+string(filepath.Separator)) {
+		return "", os.ErrPermission
+	}
+	return fn, nil
+}
+
+//uploadData 写入上传的数据块，格式：大端序uint32句柄+uint64偏移量+数据
+func (c *Client) uploadData(data []byte) {
+	if len(data) < 12 {
+		return
+	}
+	u := c.uploads[binary.BigEndian.Uint32(data[:4])]
+	if u == nil {
+		return
+	}
+	u.seen = time.Now()
+	offset := int64(binary.BigEndian.Uint64(data[4:12]))
+	n, err := u.file.WriteAt(data[12:], offset)
+	if err != nil {
+		base.Log("uploadData(%s): %v", u.name, err)
+		return
+	}
+	if end := offset + int64(n); end > u.size {
+		u.size = end
+	}
+}
+
+//closeUploads 关闭空闲超过idle的上传（idle为0表示全部），已写入的部分保留，
+//可用于续传。须在procPackets线程内调用
+func (c *Client) closeUploads(idle time.Duration, reason string) {
+	for h, u := range c.uploads {
+		if idle > 0 && time.Since(u.seen) < idle {
+			continue
+		}
+		delete(c.uploads, h)
+		u.file.Close()
+		base.Log("upload aborted: %s (%d bytes, %s)", u.name, u.size, reason)
+	}
+}
+
+//expireUploads 定期关闭空闲的上传（控制端未能关闭句柄时）
+func (c *Client) expireUploads() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+		c.call(func() interface{} {
+			c.closeUploads(uploadIdle, "idle")
+			return nil
+		})
+	}
+}
+
+//fileOp 处理文件传输命令，返回nil表示该命令需在其他线程中处理（见fileRead）
+func (c *Client) fileOp(args []byte) map[string]interface{} {
+	var fr fileReq
+	if err := json.Unmarshal(args, &fr); err != nil {
+		return failure("fileOp: %v", err)
+	}
+	switch fr.Op {
+	case "open":
+		fn, err := sandbox(c.conf().FileRoot, fr.Path)
+		if err != nil {
+			return failure("fileOp: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(fn), 0750); err != nil {
+			return failure("fileOp: %v", err)
+		}
+		f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return failure("fileOp: %v", err)
+		}
+		st, err := f.Stat()
+		if err == nil && fr.Offset > st.Size() {
+			err = fmt.Errorf("offset %d beyond file size %d", fr.Offset, st.Size())
+		}
+		if err == nil {
+			err = f.Truncate(fr.Offset) //续传时丢弃offset之后的内容
+		}
+		if err != nil {
+			f.Close()
+			return failure("fileOp: %v", err)
+		}
+		if u := c.uploads[fr.Handle]; u != nil {
+			u.file.Close()
+		}
+		c.uploads[fr.Handle] = &upload{file: f, name: fn, size: fr.Offset, seen: time.Now()}
+		base.Log("upload started: %s (offset=%d)", fn, fr.Offset)
+		return success(map[string]interface{}{"size": fr.Offset})
+	case "sync", "close":
+		u := c.uploads[fr.Handle]
+		if u == nil {
+			return failure("fileOp: invalid handle %x", fr.Handle)
+		}
+		u.seen = time.Now()
+		if fr.Op == "close" {
+			delete(c.uploads, fr.Handle)
+			if err := u.file.Close(); err != nil {
+				return failure("fileOp: %v", err)
+			}
+			base.Log("upload finished: %s (%d bytes)", u.name, u.size)
+		}
+		return success(map[string]interface{}{"size": u.size})
+	}
+	return nil
+}
+
+//fileRead 处理只读的文件传输命令（list、stat和get）
+func fileRead(cf Config, args []byte) map[string]interface{} {
+	var fr fileReq
+	if err := json.Unmarshal(args, &fr); err != nil {
+		return failure("fileRead: %v", err)
+	}
+	fn, err := sandbox(cf.FileRoot, fr.Path)
+	if err != nil {
+		return failure("fileRead: %v", err)
+	}
+	switch fr.Op {
+	case "list":
+		fis, err := ioutil.ReadDir(fn)
+		if err != nil {
+			return failure("fileRead: %v", err)
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+		list := []map[string]interface{}{}
+		for _, fi := range fis {
+			list = append(list, map[string]interface{}{
+				"name": fi.Name(),
+				"size": fi.Size(),
+				"time": fi.ModTime(),
+				"dir":  fi.IsDir(),
+			})
+		}
+		return success(list)
+	case "stat":
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return failure("fileRead: %v", err)
+		}
+		return success(map[string]interface{}{
+			"size": fi.Size(),
+			"time": fi.ModTime(),
+			"dir":  fi.IsDir(),
+		})
+	case "get":
+		f, err := os.Open(fn)
+		if err != nil {
+			return failure("fileRead: %v", err)
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil {
+			return failure("fileRead: %v", err)
+		}
+		if st.IsDir() {
+			return failure("fileRead: %s is a directory", fr.Path)
+		}
+		buf := make([]byte, maxBlock)
+		n, err := f.ReadAt(buf, fr.Offset)
+		if err != nil && err != io.EOF {
+			return failure("fileRead: %v", err)
+		}
+		return success(map[string]interface{}{
+			"size": st.Size(),
+			"data": buf[:n], //JSON编码为base64
+		})
+	}
+	return failure("fileRead: invalid op '%s'", fr.Op)
+}
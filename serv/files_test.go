@@ -0,0 +1,117 @@
+package serv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//testRoot 创建测试用的文件根目录：a/f为普通文件，in指向a，out指向根目录之外
+func testRoot(t *testing.T) string {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "a"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "a", "f"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "in")); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestSandbox(t *testing.T) {
+	root := testRoot(t)
+	cases := []struct {
+		root, path string
+		want       string //为"-"表示应当出错
+	}{
+		{"", "a/f", "-"}, //未设置file_root
+		{root, "a/f", "a/f"},
+		{root, "", ""},
+		{root, "..", ""},
+		{root, "../../etc/passwd", "etc/passwd"}, //..不能越出根目录
+		{root, "a/../../x", "x"},
+		{root, "/etc/passwd", "etc/passwd"}, //绝对路径也相对于根目录
+		{root, "in/f", "in/f"},              //指向根目录之内的符号链接
+		{root, "out", "-"},
+		{root, "out/f", "-"},
+		{root, "out/missing/f", "-"},
+		{root, "a/missing/deeper/f", "a/missing/deeper/f"}, //上级目录不存在
+	}
+	for _, c := range cases {
+		fn, err := sandbox(c.root, c.path)
+		if c.want == "-" {
+			if err == nil {
+				t.Errorf("sandbox(%q, %q) = %s, want error", c.root, c.path, fn)
+			}
+			continue
+		}
+		if want := filepath.Join(root, filepath.FromSlash(c.want)); err != nil || fn != want {
+			t.Errorf("sandbox(%q, %q) = %s, %v, want %s", c.root, c.path, fn, err, want)
+		}
+	}
+}
+
+func TestFileOp(t *testing.T) {
+	root := testRoot(t)
+	c := NewClient(Config{FileRoot: root})
+	op := func(op, path string, offset int64, handle uint32) map[string]interface{} {
+		args, _ := json.Marshal(fileReq{Op: op, Path: path, Offset: offset, Handle: handle})
+		return c.fileOp(args)
+	}
+	write := func(handle uint32, offset int64, data string) {
+		buf := make([]byte, 12, 12+len(data))
+		binary.BigEndian.PutUint32(buf, handle)
+		binary.BigEndian.PutUint64(buf[4:], uint64(offset))
+		c.uploadData(append(buf, data...))
+	}
+	cases := []struct {
+		name   string
+		rep    func() map[string]interface{}
+		ok     bool
+		size   int64
+		handle uint32 //检查该句柄是否仍然打开（0表示不检查）
+	}{
+		{"open beyond size", func() map[string]interface{} { return op("open", "a/f", 6, 1) }, false, 0, 0},
+		{"open new beyond size", func() map[string]interface{} { return op("open", "b/g", 1, 1) }, false, 0, 0},
+		{"open outside root", func() map[string]interface{} { return op("open", "out/g", 0, 1) }, false, 0, 0},
+		{"resume", func() map[string]interface{} { return op("open", "a/f", 3, 1) }, true, 3, 1},
+		{"sync", func() map[string]interface{} { write(1, 3, "p!"); return op("sync", "", 0, 1) }, true, 5, 1},
+		{"sync ignores offset", func() map[string]interface{} { return op("sync", "", 100, 1) }, true, 5, 1},
+		{"sync invalid handle", func() map[string]interface{} { return op("sync", "", 0, 2) }, false, 0, 0},
+		{"close ignores offset", func() map[string]interface{} { return op("close", "", 100, 1) }, true, 5, 0},
+		{"close again", func() map[string]interface{} { return op("close", "", 0, 1) }, false, 0, 0},
+		{"open at size", func() map[string]interface{} { return op("open", "a/f", 5, 2) }, true, 5, 2},
+		{"open beyond size keeps handle", func() map[string]interface{} { return op("open", "a/f", 6, 2) }, false, 0, 2},
+	}
+	for _, k := range cases {
+		rep := k.rep()
+		if ok := rep["stat"] == true; ok != k.ok {
+			t.Errorf("%s: stat = %v, want %v (%v)", k.name, ok, k.ok, rep["mesg"])
+			continue
+		}
+		if k.ok {
+			if size := rep["data"].(map[string]interface{})["size"].(int64); size != k.size {
+				t.Errorf("%s: size = %d, want %d", k.name, size, k.size)
+			}
+		}
+		if k.handle != 0 && c.uploads[k.handle] == nil {
+			t.Errorf("%s: handle %d closed", k.name, k.handle)
+		}
+	}
+	c.closeUploads(0, "test")
+	if data, err := ioutil.ReadFile(filepath.Join(root, "a", "f")); err != nil || string(data) != "help!" {
+		t.Errorf("uploaded file = %q, %v, want %q", data, err, "help!")
+	}
+}
@@ -0,0 +1,85 @@
+package serv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dk/base"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	started time.Time //进程启动时间
+	exeSum  struct {
+		sum string //可执行文件的SHA256，控制端据此确认自我更新是否成功
+		sync.Once
+	}
+)
+
+func init() {
+	started = time.Now()
+}
+
+//siteInfo 收集本后端的元数据，握手成功后报告给控制端
+func siteInfo(cf Config) map[string]interface{} {
+	host, _ := os.Hostname()
+	addrs := []string{}
+	ias, _ := net.InterfaceAddrs()
+	for _, a := range ias {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLoopback() || ipn.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, ipn.String())
+	}
+	nets := cf.LanNets
+	if nets == nil {
+		nets = []string{}
+	}
+	return map[string]interface{}{
+		"version":  cf.Version,
+		"checksum": checksum(),
+		"hostname": host,
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"uptime":   int(time.Since(started).Seconds()),
+		"addrs":    addrs,
+		"lan_nets": nets,
+		"features": []string{"proxy", "user"}, //支持扩展的ChunkOPN（携带客户端地址及控制端用户）
+	}
+}
+
+//checksum 返回本进程可执行文件的SHA256（首次调用时计算）
+func checksum() string {
+	exeSum.Do(func() {
+		exe, err := executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err == nil {
+			exeSum.sum = hex.EncodeToString(h.Sum(nil))
+		}
+	})
+	return exeSum.sum
+}
+
+func sendInfo(conn net.Conn, cf Config) error {
+	var msg bytes.Buffer
+	msg.WriteByte(2)
+	if err := json.NewEncoder(&msg).Encode(siteInfo(cf)); err != nil {
+		return err
+	}
+	return base.Reply(conn, 0, msg.Bytes())
+}
@@ -0,0 +1,61 @@
+package serv
+
+import (
+	"dk/base"
+	"fmt"
+	"time"
+)
+
+//checkLimits 检查新连接s是否超出并发连接数限制，返回拒绝的原因（为空表示允许）
+func (c *Client) checkLimits(s *session) string {
+	cf, peer := c.conf(), c.peer
+	if cf.MaxSess > 0 && len(peer) >= cf.MaxSess {
+		return fmt.Sprintf("too many sessions (max %d)", cf.MaxSess)
+	}
+	if cf.MaxDest > 0 {
+		cnt := 0
+		for _, p := range peer {
+			if p.dest == s.dest {
+				cnt++
+			}
+		}
+		if cnt >= cf.MaxDest {
+			return fmt.Sprintf("too many sessions to %s (max %d)", s.dest, cf.MaxDest)
+		}
+	}
+	return ""
+}
+
+//expireSessions 定时关闭超过最长时间的连接（每次都重新读取max_duration，使修改即时生效）
+func (c *Client) expireSessions() {
+	for {
+		max := time.Duration(c.conf().MaxTime) * time.Second
+		interval := max / 10
+		if interval < time.Second {
+			interval = time.Second
+		}
+		if interval > time.Minute || max == 0 {
+			interval = time.Minute
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if max = time.Duration(c.conf().MaxTime) * time.Second; max == 0 {
+			continue
+		}
+		c.call(func() interface{} {
+			for id, s := range c.peer {
+				if time.Since(s.start) < max {
+					continue
+				}
+				reason := fmt.Sprintf("session exceeded %v", max)
+				base.Log("session %x => %s: %s", id, s.dest, reason)
+				c.closeSession(id, reason)
+				base.Refuse(c.masterConn(), id, reason) //向控制端通告该后端连接关闭
+			}
+			return nil
+		})
+	}
+}
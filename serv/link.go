@@ -0,0 +1,75 @@
+package serv
+
+import (
+	"dk/base"
+	"encoding/json"
+	"time"
+)
+
+const (
+	maxLinkSize = 64 * 1024 * 1024 //链路测试的最大字节数
+	maxLinkTime = 30               //链路测试的最长时间（秒）
+)
+
+type linkReq struct {
+	Op       string `json:"op"`       //echo、begin、end或source
+	Size     int64  `json:"size"`     //发送的字节数（source）
+	Duration int    `json:"duration"` //发送的最长时间（source，秒）
+}
+
+//linkTest 处理链路测试命令，source需要发送大量数据，返回nil表示需在其他线程中处理
+func (c *Client) linkTest(args []byte) map[string]interface{} {
+	var lr linkReq
+	if err := json.Unmarshal(args, &lr); err != nil {
+		return failure("linkTest: %v", err)
+	}
+	switch lr.Op {
+	case "echo":
+		return success(nil)
+	case "begin":
+		c.sink.bytes = 0
+		c.sink.start = time.Now()
+		return success(nil)
+	case "end":
+		return success(map[string]interface{}{
+			"bytes": c.sink.bytes,
+			"time":  time.Since(c.sink.start).Seconds(),
+		})
+	case "source":
+		return nil
+	}
+	return failure("linkTest: invalid op '%s'", lr.Op)
+}
+
+//linkSource 向控制端发送测试数据，直到达到指定字节数或时间
+func (c *Client) linkSource(session uint32, args []byte) map[string]interface{} {
+	var lr linkReq
+	if err := json.Unmarshal(args, &lr); err != nil {
+		return failure("linkSource: %v", err)
+	}
+	if lr.Size <= 0 || lr.Size > maxLinkSize {
+		lr.Size = maxLinkSize
+	}
+	if lr.Duration <= 0 || lr.Duration > maxLinkTime {
+		lr.Duration = maxLinkTime
+	}
+	buf := make([]byte, base.MaxData)
+	buf[0] = 12 //命令代码，buf[1]=0表示非最后一片
+	start := time.Now()
+	deadline := start.Add(time.Duration(lr.Duration) * time.Second)
+	var sent int64
+	for sent < lr.Size && time.Now().Before(deadline) {
+		n := int64(len(buf) - 2)
+		if n > lr.Size-sent {
+			n = lr.Size - sent
+		}
+		if err := base.Reply(c.masterConn(), session, buf[:2+n]); err != nil {
+			return failure("linkSource: %v", err)
+		}
+		sent += n
+	}
+	return success(map[string]interface{}{
+		"bytes": sent,
+		"time":  time.Since(start).Seconds(),
+	})
+}
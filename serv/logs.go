@@ -0,0 +1,141 @@
+package serv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"dk/base"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	maxTail  = 1000      //tail最多返回的行数
+	maxBlock = 64 * 1024 //每次读取LOG文件的最大字节数
+)
+
+type logReq struct {
+	Op     string `json:"op"`     //list、debug、tail或get
+	Debug  bool   `json:"debug"`  //调试模式（debug）
+	File   string `json:"file"`   //LOG文件名（tail、get）
+	Lines  int    `json:"lines"`  //行数（tail）
+	Offset int64  `json:"offset"` //读取位置（get）
+}
+
+//logFile 返回LOG目录中名为name的文件路径，name必须是LOG文件或其切分文件
+func logFile(name string) (string, error) {
+	dir := base.LogPath()
+	if dir == "" {
+		return "", os.ErrNotExist
+	}
+	if name != base.LOG_FN && !strings.HasPrefix(name, base.LOG_FN+".") ||
+		strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", os.ErrPermission
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func tailLog(fn string, lines int) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(fn, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	var tail []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		tail = append(tail, s.Text())
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+	return tail, s.Err()
+}
+
+func remoteLog(args []byte) map[string]interface{} {
+	var lr logReq
+	if err := json.Unmarshal(args, &lr); err != nil {
+		return failure("remoteLog: %v", err)
+	}
+	switch lr.Op {
+	case "debug":
+		base.SetDebug(lr.Debug)
+		base.Log("debug mode set to %v by gateway", lr.Debug)
+		return success(map[string]interface{}{"debug": lr.Debug})
+	case "list":
+		files := []map[string]interface{}{}
+		if dir := base.LogPath(); dir != "" {
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return failure("remoteLog: %v", err)
+			}
+			sort.Slice(fis, func(i, j int) bool {
+				return fis[i].ModTime().After(fis[j].ModTime())
+			})
+			for _, fi := range fis {
+				if _, err := logFile(fi.Name()); err != nil || fi.IsDir() {
+					continue
+				}
+				files = append(files, map[string]interface{}{
+					"name": fi.Name(),
+					"size": fi.Size(),
+					"time": fi.ModTime(),
+				})
+			}
+		}
+		return success(map[string]interface{}{
+			"debug": base.Debugging(),
+			"files": files,
+		})
+	case "tail":
+		fn, err := logFile(lr.File)
+		if err != nil {
+			return failure("remoteLog: %v", err)
+		}
+		if lr.Lines <= 0 || lr.Lines > maxTail {
+			lr.Lines = 100
+		}
+		lines, err := tailLog(fn, lr.Lines)
+		if err != nil {
+			return failure("remoteLog: %v", err)
+		}
+		return success(lines)
+	case "get":
+		fn, err := logFile(lr.File)
+		if err != nil {
+			return failure("remoteLog: %v", err)
+		}
+		f, err := os.Open(fn)
+		if err != nil {
+			return failure("remoteLog: %v", err)
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil {
+			return failure("remoteLog: %v", err)
+		}
+		buf := make([]byte, maxBlock)
+		n, err := f.ReadAt(buf, lr.Offset)
+		if err != nil && err != io.EOF {
+			return failure("remoteLog: %v", err)
+		}
+		return success(map[string]interface{}{
+			"size": st.Size(),
+			"data": buf[:n], //JSON编码为base64
+		})
+	}
+	return failure("remoteLog: invalid op '%s'", lr.Op)
+}
@@ -0,0 +1,82 @@
+package serv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+//proxySig PROXY协议v2的签名
+var proxySig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+//parseDest 解析ChunkOPN的目标（端口+IP）。扩展格式（36字节）的目标IP为16字节，
+//后续为控制端接入的客户端端口及其16字节IP，再后可能是控制端用户（1字节长度+用户名）
+func parseDest(dest []byte) (addr, from *net.TCPAddr, user string) {
+	const ext = 2 * (2 + net.IPv6len)
+	switch {
+	case len(dest) == 2+net.IPv4len, len(dest) == 2+net.IPv6len:
+	case len(dest) == ext, len(dest) > ext && len(dest) == ext+1+int(dest[ext]):
+		if len(dest) > ext {
+			user = string(dest[ext+1:])
+		}
+		from = &net.TCPAddr{IP: net.IP(dest[20:ext]), Port: int(binary.BigEndian.Uint16(dest[18:20]))}
+		dest = dest[:18]
+	default:
+		return nil, nil, ""
+	}
+	addr = &net.TCPAddr{IP: net.IP(dest[2:]), Port: int(binary.BigEndian.Uint16(dest[:2]))}
+	return
+}
+
+//proxyFor 返回连接addr时需发送的PROXY协议版本（v1或v2），为空表示不发送。
+//proxy_protocol的键可以是"IP:端口"、"IP"或":端口"，依次匹配
+func (cf Config) proxyFor(addr *net.TCPAddr) string {
+	if addr == nil || len(cf.Proxy) == 0 {
+		return ""
+	}
+	for _, k := range []string{addr.String(), addr.IP.String(), ":" + strconv.Itoa(addr.Port)} {
+		if v, ok := cf.Proxy[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+//proxyHeader 生成PROXY协议头，from为nil（控制端未提供客户端地址）时表示来源未知
+func proxyHeader(ver string, from, to *net.TCPAddr) []byte {
+	v4 := from != nil && from.IP.To4() != nil && to.IP.To4() != nil
+	if ver == "v1" {
+		switch {
+		case from == nil:
+			return []byte("PROXY UNKNOWN\r\n")
+		case v4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", from.IP, to.IP, from.Port, to.Port))
+		case from.IP.To4() == nil && to.IP.To4() == nil:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", from.IP, to.IP, from.Port, to.Port))
+		default:
+			return []byte("PROXY UNKNOWN\r\n") //v1不支持混合地址族
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(proxySig)
+	if from == nil {
+		buf.Write([]byte{0x20, 0x00, 0, 0}) //LOCAL命令，无地址
+		return buf.Bytes()
+	}
+	port := make([]byte, 4)
+	binary.BigEndian.PutUint16(port, uint16(from.Port))
+	binary.BigEndian.PutUint16(port[2:], uint16(to.Port))
+	if v4 {
+		buf.Write([]byte{0x21, 0x11, 0, 12}) //PROXY命令，TCP over IPv4
+		buf.Write(from.IP.To4())
+		buf.Write(to.IP.To4())
+	} else {
+		buf.Write([]byte{0x21, 0x21, 0, 36}) //PROXY命令，TCP over IPv6
+		buf.Write(from.IP.To16())
+		buf.Write(to.IP.To16())
+	}
+	buf.Write(port)
+	return buf.Bytes()
+}
@@ -1,8 +1,8 @@
 package serv
 
 import (
-	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -31,7 +31,7 @@ func portScan(port uint16, cidrs []string, timeout int) (ips []string) {
 				if ip == "" {
 					break
 				}
-				target := fmt.Sprintf("%s:%d", ip, port)
+				target := net.JoinHostPort(ip, strconv.Itoa(int(port)))
 				conn, err := net.DialTimeout("tcp", target, tts)
 				if err == nil {
 					conn.Close()
@@ -0,0 +1,24 @@
+//+build linux
+
+package serv
+
+import (
+	"os"
+	"syscall"
+)
+
+//reexec 以新的可执行文件替换当前进程。started（可为nil）在替换前以新程序将使用的PID调用，
+//返回错误则放弃替换
+func reexec(exe string, env []string, started func(pid int) error) error {
+	if started != nil {
+		if err := started(os.Getpid()); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(exe, os.Args, env)
+}
+
+//alive 进程是否仍在运行
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
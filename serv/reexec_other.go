@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package serv
+
+//reexec 本平台不支持替换当前进程，自我更新将失败并恢复原程序
+func reexec(exe string, env []string, started func(pid int) error) error {
+	return errNotSupported
+}
+
+//alive 本平台无法判断，视为已退出（reexec不可用时不会启动回滚监视，不会被调用）
+func alive(pid int) bool {
+	return false
+}
@@ -0,0 +1,42 @@
+//+build windows
+
+package serv
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const stillActive = 259 //GetExitCodeProcess对仍在运行的进程返回的代码
+
+//reexec 启动新的可执行文件，然后退出当前进程（Windows不支持exec）。started（可为nil）
+//以新进程的PID调用，返回错误则结束新进程并放弃替换
+func reexec(exe string, env []string, started func(pid int) error) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if started != nil {
+		if err := started(cmd.Process.Pid); err != nil {
+			cmd.Process.Kill()
+			return err
+		}
+	}
+	os.Exit(0)
+	return nil
+}
+
+//alive 进程是否仍在运行
+func alive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
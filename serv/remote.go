@@ -0,0 +1,133 @@
+package serv
+
+import (
+	"dk/base"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const confSkew = 300 //远程配置的时间戳允许的最大偏差（秒）
+
+type (
+	confReq struct { //控制端下发的配置（已签名）
+		Conf string `json:"conf"` //JSON格式的confBody
+		Sign string `json:"sign"` //HMAC-SHA256签名（十六进制）
+	}
+	confBody struct {
+		Time     int64                      `json:"time"`     //签名时间（UNIX时间戳，纳秒）
+		Settings map[string]json.RawMessage `json:"settings"` //需修改的配置项，为空表示查询
+	}
+)
+
+//remoteSettings 列出可远程修改的配置项的当前值及是否允许修改
+func remoteSettings(cf Config) map[string]interface{} {
+	vals := map[string]interface{}{
+		"lan_nets": cf.LanNets,
+		"scan_ttl": cf.ScanTTL,
+	}
+	res := make(map[string]interface{})
+	for k, v := range vals {
+		res[k] = map[string]interface{}{"value": v, "remote": cf.Remote[k]}
+	}
+	return res
+}
+
+//applySetting 校验并修改一个配置项
+func applySetting(cf *Config, key string, val json.RawMessage) error {
+	switch key {
+	case "lan_nets":
+		var nets []string
+		if err := json.Unmarshal(val, &nets); err != nil {
+			return err
+		}
+		for _, n := range nets {
+			if _, _, err := net.ParseCIDR(n); err != nil {
+				return err
+			}
+		}
+		cf.LanNets = nets
+	case "scan_ttl":
+		var ttl int
+		if err := json.Unmarshal(val, &ttl); err != nil {
+			return err
+		}
+		if ttl < 100 || ttl > 5000 {
+			return fmt.Errorf("scan_ttl must be 100~5000")
+		}
+		cf.ScanTTL = ttl
+	default:
+		return fmt.Errorf("not a remote setting")
+	}
+	return nil
+}
+
+//remoteConf 处理控制端下发的配置，可在运行时生效的配置直接修改c.cf，并经Persist保存。
+//由procPackets调用（唯一修改c.cf的线程）
+func (c *Client) remoteConf(args []byte) (rep map[string]interface{}, changed bool) {
+	cf := c.conf()
+	var cr confReq
+	if err := json.Unmarshal(args, &cr); err != nil {
+		return failure("remoteConf: %v", err), false
+	}
+	sig, err := hex.DecodeString(cr.Sign)
+	if err != nil || !base.Verify(base.SubKey(cf.Auth, "conf"), []byte(cr.Conf), sig) {
+		return failure("remoteConf: invalid signature"), false
+	}
+	var cb confBody
+	if err := json.Unmarshal([]byte(cr.Conf), &cb); err != nil {
+		return failure("remoteConf: %v", err), false
+	}
+	now, skew := time.Now().UnixNano(), int64(confSkew*time.Second)
+	if cb.Time < now-skew || cb.Time > now+skew || cb.Time <= cf.ConfTime {
+		return failure("remoteConf: expired or replayed request"), false
+	}
+	nc := cf
+	nc.ConfTime = cb.Time
+	if len(cb.Settings) == 0 {
+		c.saveConf(nc)
+		return success(remoteSettings(nc)), false
+	}
+	applied := []string{}
+	errs := make(map[string]string)
+	for k, v := range cb.Settings {
+		if !cf.Remote[k] {
+			errs[k] = "remote change not allowed"
+			continue
+		}
+		if err := applySetting(&nc, k, v); err != nil {
+			errs[k] = err.Error()
+			continue
+		}
+		applied = append(applied, k)
+	}
+	data := map[string]interface{}{"applied": applied, "errors": errs}
+	if len(applied) == 0 {
+		nc = cf
+		nc.ConfTime = cb.Time
+		c.saveConf(nc)
+		return map[string]interface{}{"stat": false, "mesg": "no setting applied", "data": data}, false
+	}
+	base.Log("remote configuration applied: %v", applied)
+	if err := c.saveConf(nc); err != nil {
+		data["persist"] = err.Error()
+	}
+	data["settings"] = remoteSettings(nc)
+	return success(data), true
+}
+
+//saveConf 使配置cf生效并经Persist保存。每个被接受的请求（包括查询）都会保存ConfTime，
+//使重启后仍能拒绝重放的请求；未设置Persist（作为库使用）时只在内存中防止重放
+func (c *Client) saveConf(cf Config) error {
+	c.setConf(cf)
+	if cf.Persist == nil {
+		return nil
+	}
+	err := cf.Persist(cf)
+	if err != nil {
+		base.Log("remoteConf: persist: %v", err)
+	}
+	return err
+}
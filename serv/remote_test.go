@@ -0,0 +1,70 @@
+package serv
+
+import (
+	"dk/base"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+//signedConf 生成以auth签名的远程配置请求
+func signedConf(t *testing.T, auth string, ts int64, settings map[string]interface{}) []byte {
+	body, _ := json.Marshal(map[string]interface{}{"time": ts, "settings": settings})
+	args, err := json.Marshal(confReq{
+		Conf: string(body),
+		Sign: hex.EncodeToString(base.Sign(base.SubKey(auth, "conf"), body)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return args
+}
+
+func TestRemoteConfReplay(t *testing.T) {
+	var saved Config
+	cf := Config{
+		Name:    "site1",
+		Auth:    "secret",
+		Remote:  map[string]bool{"scan_ttl": true},
+		Persist: func(cf Config) error { saved = cf; return nil },
+	}
+	now := time.Now().UnixNano()
+	req := signedConf(t, "secret", now, map[string]interface{}{"scan_ttl": 700})
+	c := NewClient(cf)
+	cases := []struct {
+		name string
+		args []byte
+		ok   bool
+	}{
+		{"first", req, true},
+		{"replayed", req, false},
+		{"older", signedConf(t, "secret", now-1, map[string]interface{}{"scan_ttl": 800}), false},
+		{"expired", signedConf(t, "secret", now-int64(2*confSkew*time.Second), nil), false},
+		{"wrong key", signedConf(t, "other", now+2, nil), false},
+		{"handshake key", func() []byte { //以共享密钥本身签名的请求不被接受
+			body, _ := json.Marshal(map[string]interface{}{"time": now + 3})
+			args, _ := json.Marshal(confReq{Conf: string(body), Sign: hex.EncodeToString(base.Sign("secret", body))})
+			return args
+		}(), false},
+		{"not allowed", signedConf(t, "secret", now+4, map[string]interface{}{"lan_nets": []string{}}), false},
+		{"newer", signedConf(t, "secret", now+5, map[string]interface{}{"scan_ttl": 900}), true},
+	}
+	for _, c2 := range cases {
+		rep, _ := c.remoteConf(c2.args)
+		if ok := rep["stat"] == true; ok != c2.ok {
+			t.Errorf("%s: stat = %v, want %v (%v)", c2.name, ok, c2.ok, rep["mesg"])
+		}
+	}
+	if got := c.conf().ScanTTL; got != 900 {
+		t.Errorf("scan_ttl = %d, want 900", got)
+	}
+	//重启后（以保存的配置创建后端）仍拒绝重放
+	if saved.ConfTime != now+5 {
+		t.Fatalf("persisted conf time = %d, want %d", saved.ConfTime, now+5)
+	}
+	c = NewClient(saved)
+	if rep, _ := c.remoteConf(req); rep["stat"] == true {
+		t.Errorf("request replayed after restart")
+	}
+}
@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	queueCap = 1024 //包处理队列长度
+	//chunkRST 与控制端的连接已断开（内部使用），关闭所有目标连接
+	chunkRST base.ChunkType = 6
+	//chunkCTL 本地控制API的调用（内部使用）
+	chunkCTL base.ChunkType = 7
 )
 
 type (
@@ -20,99 +25,88 @@ type (
 		ct   base.ChunkType
 		buf  []byte
 		conn net.Conn
+		call func() //chunkCTL要执行的操作
 	}
 )
 
-var (
-	master net.Conn
-	peer   map[uint32]*base.Conn //维护所有目标连接，索引为SESSION-ID
-	ch     chan packet
-)
-
-func init() {
-	ch = make(chan packet, queueCap)
+//post 将包交给procPackets处理，后端停止后丢弃
+func (c *Client) post(p packet) {
+	select {
+	case c.ch <- p:
+	case <-c.ctx.Done():
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	}
 }
 
-func procPackets(cf Config) {
+func (c *Client) procPackets() {
+	peer := c.peer
 	for {
 		var session uint32
 		var data []byte
-		p := <-ch
+		var p packet
+		select {
+		case p = <-c.ch:
+		case <-c.ctx.Done():
+			for id := range peer {
+				c.closeSession(id, "client stopped")
+			}
+			c.closeUploads(0, "client stopped")
+			return
+		}
+		cf := c.conf()
 		if len(p.buf) >= 4 {
 			session = binary.BigEndian.Uint32(p.buf[:4])
 			data = p.buf[4:]
 		}
 		switch p.ct {
 		case base.ChunkCLS:
-			c := peer[session]
-			if c == nil {
+			if peer[session] == nil {
 				base.Dbg("session %x not found, cannot finish", session)
 				break
 			}
-			c.Close()
-			delete(peer, session)
+			c.closeSession(session, "closed by gateway")
 			base.Dbg("backend finished session %x", session)
 		case base.ChunkOPN:
-			old := peer[session]
-			if old != nil {
-				old.Close()
-				delete(peer, session)
+			c.closeSession(session, "reopened by gateway")
+			s := newSession(session, data)
+			s.proxy = cf.proxyFor(s.addr)
+			reason := c.checkLimits(s)
+			if atomic.LoadInt32(&c.paused) == 1 {
+				reason = "paused by local user"
 			}
-			peer[session] = base.NewConn(nil)
-			go func(session uint32, dest []byte) {
-				if len(dest) != 2+net.IPv4len && len(dest) != 2+net.IPv6len {
-					base.Log("ChunkOPN: invalid destination")
-					return
-				}
-				port := binary.BigEndian.Uint16(dest[:2])
-				ip := net.IP(dest[2:])
-				addr := fmt.Sprintf("%s:%d", ip.String(), port)
-				base.Dbg("open session %x => %s", session, addr)
-				d := net.Dialer{Timeout: time.Duration(base.TIMEOUT) * time.Second}
-				conn, err := d.Dial("tcp", addr)
-				data := make([]byte, 4)
-				binary.BigEndian.PutUint32(data, session)
-				var p packet
-				if err != nil {
-					p = packet{ct: base.ChunkCON, buf: append(data, []byte(err.Error())...)}
-				} else {
-					p = packet{ct: base.ChunkCON, buf: data, conn: conn}
-					go func(s uint32, c net.Conn) {
-						defer func() {
-							if e := recover(); e != nil {
-								msg := make([]byte, 4)
-								binary.BigEndian.PutUint32(msg, s)
-								msg = append(msg, []byte(e.(error).Error())...)
-								ch <- packet{ct: base.ChunkCON, buf: msg}
-							}
-						}()
-						data := make([]byte, base.MTU-2)
-						for {
-							n, err := c.Read(data)
-							assert(err)
-							assert(base.Send(master, session, data[:n]))
-						}
-					}(session, conn)
-				}
-				ch <- p
-			}(session, data)
+			if reason != "" {
+				base.Log("session %x => %s: refused (%s)", session, s.dest, reason)
+				c.audit(s, "close", "refused: "+reason)
+				base.Refuse(c.masterConn(), session, reason) //向控制端通告拒绝连接及其原因
+				break
+			}
+			peer[session] = s
+			if cf.Consent.Enable {
+				c.askConsent(s)
+				break
+			}
+			go c.openSession(s)
 		case base.ChunkDAT:
-			c := peer[session]
-			if c == nil {
+			s := peer[session]
+			if s == nil {
 				base.Dbg("dispatch[%x]: dropped %d bytes", session, len(data))
-				base.Close(master, session) //向控制端通告该后端连接关闭
+				base.Close(c.masterConn(), session) //向控制端通告该后端连接关闭
 				break
 			}
-			if err := c.Send(data); err != nil {
+			if err := s.Send(data); err != nil {
 				base.Log("dispatch[%x]: %v", session, err)
-				base.Close(master, session) //向控制端通告该后端连接关闭
-				delete(peer, session)
+				base.Close(c.masterConn(), session) //向控制端通告该后端连接关闭
+				c.closeSession(session, err.Error())
+				break
 			}
+			atomic.AddInt64(&s.sent, int64(len(data)))
 		case base.ChunkCMD:
 			switch data[0] {
 			case 0:
 				base.Dbg("received ping from gateway")
-				if err := base.Ping(master); err != nil {
+				if err := base.Ping(c.masterConn()); err != nil {
 					base.Log("pong: %v", err)
 				}
 			case 1:
@@ -133,18 +127,82 @@ func procPackets(cf Config) {
 						"data": hosts,
 					})
 				}
-				if err := base.Reply(master, session, msg.Bytes()); err != nil {
+				if err := base.Reply(c.masterConn(), session, msg.Bytes()); err != nil {
 					base.Log("reply(scan#%d): %v", port, err)
 				}
+			case 3:
+				go func(session uint32, args []byte) {
+					c.respond(session, 3, diagnose(args))
+				}(session, data[1:])
+			case 4:
+				go func(session uint32, args []byte) {
+					c.respond(session, 4, wakeOnLan(args))
+				}(session, data[1:])
+			case 5: //修改配置需在本线程内进行，以免与其他命令冲突
+				rep, changed := c.remoteConf(data[1:])
+				c.respond(session, 5, rep)
+				if changed {
+					if err := sendInfo(c.masterConn(), c.conf()); err != nil {
+						base.Log("sendInfo: %v", err)
+					}
+				}
+			case 6: //更新相关的操作需在本线程内进行，以保证与数据块的顺序，提交则另起线程
+				rep, job := c.selfUpdate(data[1:])
+				if job == nil {
+					c.respond(session, 6, rep)
+					break
+				}
+				go func(session uint32, job *updateJob) {
+					c.respond(session, 6, c.commitUpdate(job))
+				}(session, job)
+			case 7: //自我更新的数据块，无需回复
+				c.updateData(data[1:])
+			case 8:
+				go func(session uint32, args []byte) {
+					c.respond(session, 8, remoteLog(args))
+				}(session, data[1:])
+			case 9:
+				go func(session uint32, cf Config, args []byte) {
+					c.respond(session, 9, c.execute(session, cf, args))
+				}(session, cf, data[1:])
+			case 10: //上传相关的操作需在本线程内进行，以保证与数据块的顺序
+				if rep := c.fileOp(data[1:]); rep != nil {
+					c.respond(session, 10, rep)
+					break
+				}
+				go func(session uint32, cf Config, args []byte) {
+					c.respond(session, 10, fileRead(cf, args))
+				}(session, cf, data[1:])
+			case 11: //上传的数据块，无需回复
+				c.uploadData(data[1:])
+			case 12:
+				if rep := c.linkTest(data[1:]); rep != nil {
+					c.respond(session, 12, rep)
+					break
+				}
+				go func(session uint32, args []byte) {
+					c.respond(session, 12, c.linkSource(session, args))
+				}(session, data[1:])
+			case 13: //链路测试数据，只计数
+				c.sink.bytes += int64(len(data) - 1)
+			default:
+				if data[0] >= minHookCode {
+					go c.hook(session, data[0], data[1:])
+				}
 			}
+		case chunkACK:
+			c.procConsent(session, len(data) > 0 && data[0] == 1)
+		case chunkCTL:
+			p.call()
+		case chunkRST:
+			for id := range peer {
+				c.closeSession(id, "gateway disconnected")
+			}
+			c.closeUploads(0, "gateway disconnected")
 		case base.ChunkCON:
 			if p.conn == nil {
 				base.Log("session %x aborted (%s)", session, string(data))
-				bad := peer[session]
-				if bad != nil {
-					bad.Close()
-				}
-				delete(peer, session)
+				c.closeSession(session, string(data))
 				break
 			}
 			s := peer[session]
@@ -154,24 +212,78 @@ func procPackets(cf Config) {
 				break
 			}
 			s.Connect(p.conn)
+			c.audit(s, "open", "")
 			if err := s.Send(nil); err != nil {
 				base.Log("backlog[%x]: %v", session, err)
-				base.Close(master, session) //向控制端通告该后端连接关闭
-				delete(peer, session)
+				base.Close(c.masterConn(), session) //向控制端通告该后端连接关闭
+				c.closeSession(session, err.Error())
 			}
 		}
 	}
 }
 
-func serve(conn net.Conn, cf Config) {
-	peer = make(map[uint32]*base.Conn)
-	master = conn
+//openSession 连接目标，连接结果交给procPackets处理
+func (c *Client) openSession(s *session) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, s.id)
+	if s.addr == nil {
+		base.Log("ChunkOPN: invalid destination")
+		c.post(packet{ct: base.ChunkCON, buf: append(data, "invalid destination"...)})
+		return
+	}
+	base.Dbg("open session %x => %s", s.id, s.dest)
+	d := net.Dialer{Timeout: time.Duration(base.TIMEOUT) * time.Second}
+	conn, err := d.DialContext(c.ctx, "tcp", s.dest)
+	if err == nil && s.proxy != "" { //向目标发送PROXY协议头，告知真实的客户端地址
+		if _, err = conn.Write(proxyHeader(s.proxy, s.from, s.addr)); err != nil {
+			conn.Close()
+		}
+	}
+	var p packet
+	if err != nil {
+		p = packet{ct: base.ChunkCON, buf: append(data, []byte(err.Error())...)}
+	} else {
+		p = packet{ct: base.ChunkCON, buf: data, conn: conn}
+		go func(s *session, conn net.Conn) {
+			defer func() {
+				if e := recover(); e != nil {
+					msg := make([]byte, 4)
+					binary.BigEndian.PutUint32(msg, s.id)
+					msg = append(msg, []byte(e.(error).Error())...)
+					c.post(packet{ct: base.ChunkCON, buf: msg})
+				}
+			}()
+			data := make([]byte, base.MaxData)
+			for {
+				n, err := conn.Read(data)
+				assert(err)
+				atomic.AddInt64(&s.recv, int64(n))
+				assert(base.Send(c.masterConn(), s.id, data[:n]))
+			}
+		}(s, conn)
+	}
+	c.post(p)
+}
+
+func (c *Client) serve(conn net.Conn) {
+	c.Lock()
+	c.master = conn
+	c.Unlock()
+	if c.ctx.Err() != nil { //Stop与连接建立同时发生
+		conn.Close()
+	}
+	atomic.StoreInt64(&c.online, time.Now().UnixNano())
+	defer atomic.StoreInt64(&c.online, 0)
+	defer c.post(packet{ct: chunkRST})
+	if err := sendInfo(conn, c.conf()); err != nil {
+		base.Log("sendInfo: %v", err)
+	}
 	for {
-		ct, buf, err := base.Recv(master)
+		ct, buf, err := base.Recv(conn)
 		if err != nil {
 			base.Log("recv: %v", err)
 			return
 		}
-		ch <- packet{ct: ct, buf: buf}
+		c.post(packet{ct: ct, buf: buf})
 	}
 }
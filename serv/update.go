@@ -0,0 +1,311 @@
+package serv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dk/base"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	confirmAfter = 10                  //与控制端保持连接超过该秒数，视为更新成功
+	watchEnv     = "DK_ROLLBACK_WATCH" //回滚监视进程的环境变量，格式："PID 时限 可执行文件"
+)
+
+type (
+	updateReq struct {
+		Op     string `json:"op"`     //begin、sync或commit
+		Size   int64  `json:"size"`   //新程序的字节数（begin）
+		SHA256 string `json:"sha256"` //新程序的SHA256（begin）
+		Sign   string `json:"sign"`   //以共享密钥对SHA256的签名（begin）
+		Wait   int    `json:"wait"`   //重启后等待重连的最长时间（commit，秒）
+	}
+	updateJob struct {
+		file *os.File
+		size int64
+		recv int64
+		hash []byte
+		wait int //commit时指定的等待重连时间（秒）
+	}
+)
+
+var (
+	watching sync.Once
+	watcher  *exec.Cmd //回滚监视进程
+)
+
+//init 若本进程为回滚监视进程，则只执行监视（不返回）
+func init() {
+	if w := os.Getenv(watchEnv); w != "" {
+		rollbackWatch(w)
+		os.Exit(0)
+	}
+}
+
+//executable 返回当前可执行文件的真实路径
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+//updateData 写入新程序的数据块，格式：大端序uint64偏移量+数据
+func (c *Client) updateData(data []byte) {
+	upd := c.upd
+	if upd == nil || len(data) < 8 {
+		return
+	}
+	offset := int64(binary.BigEndian.Uint64(data[:8]))
+	n, err := upd.file.WriteAt(data[8:], offset)
+	if err != nil {
+		base.Log("updateData: %v", err)
+		return
+	}
+	if end := offset + int64(n); end > upd.recv {
+		upd.recv = end
+	}
+}
+
+//selfUpdate 处理自我更新的操作（在procPackets中执行，以保证与数据块的顺序）。
+//commit不在此执行，而是返回待提交的更新，由调用者以commitUpdate在独立的线程中完成
+func (c *Client) selfUpdate(args []byte) (map[string]interface{}, *updateJob) {
+	if !c.conf().SelfUpdate {
+		return failure("selfUpdate: not allowed"), nil
+	}
+	var ur updateReq
+	if err := json.Unmarshal(args, &ur); err != nil {
+		return failure("selfUpdate: %v", err), nil
+	}
+	if atomic.LoadInt32(&c.updating) != 0 {
+		return failure("selfUpdate: commit in progress"), nil
+	}
+	switch ur.Op {
+	case "begin":
+		exe, err := executable()
+		if err != nil {
+			return failure("selfUpdate: %v", err), nil
+		}
+		hash, err := hex.DecodeString(ur.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return failure("selfUpdate: invalid checksum"), nil
+		}
+		sig, err := hex.DecodeString(ur.Sign)
+		if err != nil || !base.Verify(base.SubKey(c.conf().Auth, "update"), hash, sig) {
+			return failure("selfUpdate: invalid signature"), nil
+		}
+		if c.upd != nil {
+			c.upd.file.Close()
+		}
+		f, err := os.OpenFile(exe+".new", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
+		if err != nil {
+			return failure("selfUpdate: %v", err), nil
+		}
+		c.upd = &updateJob{file: f, size: ur.Size, hash: hash}
+		base.Log("self update started (%d bytes)", ur.Size)
+		return success(map[string]interface{}{"recv": 0}), nil
+	case "sync":
+		if c.upd == nil {
+			return failure("selfUpdate: no update in progress"), nil
+		}
+		return success(map[string]interface{}{"recv": c.upd.recv}), nil
+	case "commit":
+		if c.upd == nil {
+			return failure("selfUpdate: no update in progress"), nil
+		}
+		job := c.upd
+		c.upd = nil
+		job.wait = ur.Wait
+		if job.wait <= 0 {
+			job.wait = 120
+		}
+		atomic.StoreInt32(&c.updating, 1) //提交完成（或失败）前拒绝其他更新操作
+		return nil, job
+	}
+	return failure("selfUpdate: invalid op '%s'", ur.Op), nil
+}
+
+//commitUpdate 校验收到的新程序，替换可执行文件并重启。校验整个文件较为耗时，
+//因此不在procPackets中执行
+func (c *Client) commitUpdate(job *updateJob) (rep map[string]interface{}) {
+	defer func() {
+		if rep["stat"] != true {
+			atomic.StoreInt32(&c.updating, 0)
+		}
+	}()
+	defer job.file.Close()
+	exe, err := executable()
+	if err != nil {
+		return failure("selfUpdate: %v", err)
+	}
+	fn := exe + ".new"
+	if job.recv != job.size {
+		os.Remove(fn)
+		return failure("selfUpdate: size mismatch (%d/%d)", job.recv, job.size)
+	}
+	h := sha256.New()
+	if _, err := job.file.Seek(0, io.SeekStart); err != nil {
+		return failure("selfUpdate: %v", err)
+	}
+	if _, err := io.Copy(h, job.file); err != nil {
+		return failure("selfUpdate: %v", err)
+	}
+	if !bytes.Equal(h.Sum(nil), job.hash) {
+		os.Remove(fn)
+		return failure("selfUpdate: checksum mismatch")
+	}
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		return failure("selfUpdate: %v", err)
+	}
+	if err := os.Rename(fn, exe); err != nil {
+		os.Rename(exe+".old", exe)
+		return failure("selfUpdate: %v", err)
+	}
+	//回滚标记记录回滚时限，新程序与控制端保持连接超过confirmAfter秒后将其删除
+	deadline := time.Now().Add(time.Duration(job.wait) * time.Second).Unix()
+	mark := []byte(strconv.FormatInt(deadline, 10))
+	if err := ioutil.WriteFile(exe+".rollback", mark, 0644); err != nil {
+		os.Remove(exe)
+		os.Rename(exe+".old", exe)
+		return failure("selfUpdate: %v", err)
+	}
+	go func() {
+		time.Sleep(time.Second) //等待回复送达控制端
+		base.Log("self update: restarting (rollback after %d seconds)", job.wait)
+		time.Sleep(time.Second) //等待LOG写入
+		err := reexec(exe, os.Environ(), func(pid int) error {
+			return startWatch(exe, pid, deadline)
+		})
+		//未能启动新程序，恢复旧版本，本进程继续运行
+		base.Log("self update: reexec: %v", err)
+		os.Remove(exe + ".rollback") //监视进程随之退出
+		if watcher != nil {
+			go watcher.Wait()
+		}
+		os.Rename(exe, exe+".bad")
+		if err := os.Rename(exe+".old", exe); err != nil {
+			base.Log("self update: restore: %v", err)
+		}
+		atomic.StoreInt32(&c.updating, 0)
+		if m := c.masterConn(); m != nil { //控制端据此得知更新失败
+			if err := sendInfo(m, c.conf()); err != nil {
+				base.Log("sendInfo: %v", err)
+			}
+		}
+	}()
+	return success(map[string]interface{}{"recv": job.recv, "wait": job.wait})
+}
+
+//startWatch 以旧程序启动回滚监视进程，监视即将运行新程序的进程pid。
+//监视在独立的进程中进行，新程序启动即崩溃时也能回滚
+func startWatch(exe string, pid int, deadline int64) error {
+	cmd := exec.Command(exe+".old", os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d %d %s", watchEnv, pid, deadline, exe))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	watcher = cmd
+	//在回滚标记中记录监视进程的PID，以便新程序回收已退出的监视进程
+	mark := fmt.Sprintf("%d %d", deadline, cmd.Process.Pid)
+	return ioutil.WriteFile(exe+".rollback", []byte(mark), 0644)
+}
+
+//rollbackWatch 回滚监视：回滚标记被删除（新程序已确认或未能启动）则退出；新程序的进程
+//退出或时限已过仍未确认，则结束新程序、恢复旧版本并以旧程序取代本进程运行
+func rollbackWatch(arg string) {
+	w := strings.SplitN(arg, " ", 3)
+	if len(w) != 3 {
+		return
+	}
+	pid, _ := strconv.Atoi(w[0])
+	dl, _ := strconv.ParseInt(w[1], 10, 64)
+	exe := w[2]
+	var reason string
+	for {
+		time.Sleep(time.Second)
+		if _, err := os.Stat(exe + ".rollback"); err != nil {
+			return
+		}
+		if !alive(pid) {
+			reason = "new program exited"
+			break
+		}
+		if time.Now().Unix() > dl {
+			reason = "not connected to gateway in time"
+			if p, err := os.FindProcess(pid); err == nil {
+				p.Kill()
+			}
+			break
+		}
+	}
+	os.Remove(exe + ".rollback")
+	os.Rename(exe, exe+".bad")
+	if err := os.Rename(exe+".old", exe); err != nil {
+		ioutil.WriteFile(exe+".rolledback", []byte("rollback failed: "+err.Error()), 0644)
+		return
+	}
+	ioutil.WriteFile(exe+".rolledback", []byte(reason), 0644)
+	os.Args[0] = exe
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, watchEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	reexec(exe, env, nil)
+}
+
+//watchUpdate 若存在回滚标记（即本进程为自我更新后启动），则在与控制端建立稳定连接后
+//删除标记以确认更新（本进程中的任一后端保持连接即可），否则由回滚监视进程回滚。
+//若本进程为回滚后的旧程序，则记录回滚原因
+func watchUpdate() {
+	watching.Do(watchRollback)
+}
+
+func watchRollback() {
+	exe, err := executable()
+	if err != nil {
+		return
+	}
+	if reason, err := ioutil.ReadFile(exe + ".rolledback"); err == nil {
+		base.Log("self update: rolled back (%s)", reason)
+		os.Remove(exe + ".rolledback")
+	}
+	mark, err := ioutil.ReadFile(exe + ".rollback")
+	if err != nil {
+		return
+	}
+	var dl int64
+	var wpid int
+	fmt.Sscan(string(mark), &dl, &wpid)
+	go func() {
+		for time.Now().Unix() < dl {
+			time.Sleep(time.Second)
+			if isOnline(confirmAfter * time.Second) {
+				os.Remove(exe + ".rollback")
+				base.Log("self update: confirmed")
+				if p, err := os.FindProcess(wpid); err == nil && wpid > 0 {
+					p.Wait() //监视进程随之退出
+				}
+				return
+			}
+		}
+		base.Log("self update: not connected to gateway, waiting for rollback")
+	}()
+}
@@ -0,0 +1,132 @@
+package serv
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+)
+
+type wolReq struct {
+	MAC   string `json:"mac"`   //目标主机MAC地址
+	Bcast string `json:"bcast"` //广播地址（为空则使用所有局域网接口的广播地址）
+	Host  string `json:"host"`  //唤醒后检查的目标主机
+	Port  int    `json:"port"`  //唤醒后检查的目标端口
+	Wait  int    `json:"wait"`  //等待目标端口开放的最长时间（秒）
+}
+
+//magicPacket 生成WOL魔术包：6字节0xFF，后接16次重复的MAC地址
+func magicPacket(mac net.HardwareAddr) []byte {
+	pkt := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for i := 0; i < 16; i++ {
+		pkt = append(pkt, mac...)
+	}
+	return pkt
+}
+
+//broadcasts 列出所有局域网IPv4接口的地址及其广播地址
+func broadcasts() map[string]net.IP {
+	bs := make(map[string]net.IP)
+	ifs, _ := net.Interfaces()
+	for _, i := range ifs {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagBroadcast == 0 ||
+			i.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		as, _ := i.Addrs()
+		for _, a := range as {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.To4() == nil {
+				continue
+			}
+			ip, mask := ipn.IP.To4(), net.IP(ipn.Mask).To4()
+			if mask == nil { //掩码可能是16字节格式
+				mask = net.IP(ipn.Mask[len(ipn.Mask)-4:])
+			}
+			bc := make(net.IP, 4)
+			for j := range bc {
+				bc[j] = ip[j] | ^mask[j]
+			}
+			bs[ip.String()] = bc
+		}
+	}
+	return bs
+}
+
+func wakeOnLan(args []byte) map[string]interface{} {
+	var wr wolReq
+	if err := json.Unmarshal(args, &wr); err != nil {
+		return failure("wakeOnLan: %v", err)
+	}
+	mac, err := net.ParseMAC(wr.MAC)
+	if err != nil || len(mac) != 6 {
+		return failure("wakeOnLan: invalid MAC '%s'", wr.MAC)
+	}
+	pkt := magicPacket(mac)
+	targets := make(map[string]net.IP) //本地地址=>广播地址
+	if wr.Bcast != "" {
+		ip := net.ParseIP(wr.Bcast)
+		if ip == nil || ip.To4() == nil {
+			return failure("wakeOnLan: invalid broadcast address '%s'", wr.Bcast)
+		}
+		targets[""] = ip.To4()
+	} else {
+		targets = broadcasts()
+		if len(targets) == 0 {
+			targets[""] = net.IPv4bcast
+		}
+	}
+	sent := []map[string]interface{}{}
+	var ok bool
+	for local, bc := range targets {
+		res := map[string]interface{}{"bcast": bc.String()}
+		if local != "" {
+			res["local"] = local
+		}
+		err := func() error {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(local)})
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = conn.WriteTo(pkt, &net.UDPAddr{IP: bc, Port: 9})
+			return err
+		}()
+		if err != nil {
+			res["error"] = err.Error()
+		} else {
+			ok = true
+		}
+		sent = append(sent, res)
+	}
+	data := map[string]interface{}{"mac": mac.String(), "sent": sent}
+	if !ok {
+		return map[string]interface{}{"stat": false, "mesg": "magic packet not sent", "data": data}
+	}
+	if wr.Host != "" && wr.Port > 0 && wr.Wait > 0 {
+		addr := net.JoinHostPort(wr.Host, strconv.Itoa(wr.Port))
+		start := time.Now()
+		deadline := start.Add(time.Duration(wr.Wait) * time.Second)
+		up := false
+		for time.Now().Before(deadline) {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				up = true
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		data["addr"] = addr
+		data["up"] = up
+		data["wait"] = int(time.Since(start).Seconds())
+		if !up {
+			return map[string]interface{}{
+				"stat": false,
+				"mesg": addr + " not open after wake up",
+				"data": data,
+			}
+		}
+	}
+	return success(data)
+}
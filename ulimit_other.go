@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import "dk/base"
+
+func ulimit(soft uint64) error {
+	base.Log("ulimit() not supported on this platform")
+	return nil
+}
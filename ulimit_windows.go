@@ -2,7 +2,9 @@
 
 package main
 
+import "dk/base"
+
 func ulimit(soft uint64) error {
-	L.Log("ulimit() not supported on windows")
+	base.Log("ulimit() not supported on windows")
 	return nil
 }